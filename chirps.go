@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/dbfletcher/chirpy/internal/auth"
+	"github.com/dbfletcher/chirpy/internal/database"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const maxChirpLength = 140
+
+const (
+	chirpKindChirp   = "chirp"
+	chirpKindRechirp = "rechirp"
+	chirpKindQuote   = "quote"
+)
+
+var errReferencedChirpNotFound = errors.New("referenced chirp not found")
+
+// chirpFromDB maps a database row onto the API representation. Fields that
+// need extra queries are filled in by buildChirps.
+func chirpFromDB(dbChirp database.Chirp) Chirp {
+	chirp := Chirp{
+		ID:        dbChirp.ID,
+		CreatedAt: dbChirp.CreatedAt,
+		UpdatedAt: dbChirp.UpdatedAt,
+		Body:      dbChirp.Body,
+		UserID:    dbChirp.UserID,
+		Kind:      dbChirp.Kind,
+		// Both timestamps are set together on insert, so only an edit
+		// moves updated_at past created_at.
+		Edited: dbChirp.UpdatedAt.After(dbChirp.CreatedAt),
+	}
+	if dbChirp.InReplyTo.Valid {
+		inReplyTo := dbChirp.InReplyTo.UUID
+		chirp.InReplyTo = &inReplyTo
+	}
+	// Deleting a chirp nulls in_reply_to on its replies, but they are
+	// still replies.
+	chirp.ParentDeleted = dbChirp.IsReply && !dbChirp.InReplyTo.Valid
+	return chirp
+}
+
+// buildChirps converts chirps for a response, batching the lookups for
+// derived fields so a listing costs a fixed number of queries. viewerID is
+// the authenticated caller, or uuid.Nil for anonymous requests.
+func (cfg *apiConfig) buildChirps(ctx context.Context, dbChirps []database.Chirp, viewerID uuid.UUID) ([]Chirp, error) {
+	chirps, err := cfg.buildChirpsWithoutReferences(ctx, dbChirps, viewerID)
+	if err != nil {
+		return nil, err
+	}
+
+	referencedIDs := []uuid.UUID{}
+	for _, dbChirp := range dbChirps {
+		if dbChirp.ReferencedChirpID.Valid {
+			referencedIDs = append(referencedIDs, dbChirp.ReferencedChirpID.UUID)
+		}
+	}
+	referencedByID := map[uuid.UUID]*Chirp{}
+	if len(referencedIDs) > 0 {
+		dbReferenced, err := cfg.DB.GetChirpsByIDs(ctx, referencedIDs)
+		if err != nil {
+			return nil, err
+		}
+		// Only one level is embedded: a quote of a quote shows the inner
+		// quote without its own referenced chirp.
+		referenced, err := cfg.buildChirpsWithoutReferences(ctx, dbReferenced, viewerID)
+		if err != nil {
+			return nil, err
+		}
+		for i := range referenced {
+			referencedByID[referenced[i].ID] = &referenced[i]
+		}
+	}
+
+	for i, dbChirp := range dbChirps {
+		if dbChirp.Kind == chirpKindChirp {
+			continue
+		}
+		// The referenced chirp is gone if the foreign key was nulled out by
+		// its deletion or if it was deleted since we read this row.
+		embedded, ok := referencedByID[dbChirp.ReferencedChirpID.UUID]
+		if !dbChirp.ReferencedChirpID.Valid || !ok {
+			chirps[i].ReferencedChirp = &EmbeddedChirp{Deleted: true}
+			continue
+		}
+		chirps[i].ReferencedChirp = &EmbeddedChirp{Chirp: embedded}
+	}
+
+	return chirps, nil
+}
+
+// buildChirpsWithoutReferences fills in everything buildChirps does except
+// the embedded rechirped or quoted chirps.
+func (cfg *apiConfig) buildChirpsWithoutReferences(ctx context.Context, dbChirps []database.Chirp, viewerID uuid.UUID) ([]Chirp, error) {
+	chirps := make([]Chirp, 0, len(dbChirps))
+	if len(dbChirps) == 0 {
+		return chirps, nil
+	}
+
+	chirpIDs := make([]uuid.UUID, 0, len(dbChirps))
+	for _, dbChirp := range dbChirps {
+		chirpIDs = append(chirpIDs, dbChirp.ID)
+	}
+
+	replyCounts, err := cfg.DB.CountRepliesForChirps(ctx, chirpIDs)
+	if err != nil {
+		return nil, err
+	}
+	replyCountByID := make(map[uuid.UUID]int64, len(replyCounts))
+	for _, row := range replyCounts {
+		replyCountByID[row.ChirpID] = row.ReplyCount
+	}
+
+	likeCounts, err := cfg.DB.CountLikesForChirps(ctx, chirpIDs)
+	if err != nil {
+		return nil, err
+	}
+	likeCountByID := make(map[uuid.UUID]int64, len(likeCounts))
+	for _, row := range likeCounts {
+		likeCountByID[row.ChirpID] = row.LikeCount
+	}
+
+	dbMentions, err := cfg.DB.GetMentionsForChirps(ctx, chirpIDs)
+	if err != nil {
+		return nil, err
+	}
+	mentionsByID := map[uuid.UUID][]database.ChirpMention{}
+	for _, dbMention := range dbMentions {
+		mentionsByID[dbMention.ChirpID] = append(mentionsByID[dbMention.ChirpID], dbMention)
+	}
+
+	var likedByViewer map[uuid.UUID]bool
+	if viewerID != uuid.Nil {
+		likedIDs, err := cfg.DB.GetLikedChirpIDs(ctx, database.GetLikedChirpIDsParams{
+			UserID:   viewerID,
+			ChirpIds: chirpIDs,
+		})
+		if err != nil {
+			return nil, err
+		}
+		likedByViewer = make(map[uuid.UUID]bool, len(likedIDs))
+		for _, likedID := range likedIDs {
+			likedByViewer[likedID] = true
+		}
+	}
+
+	for _, dbChirp := range dbChirps {
+		chirp := chirpFromDB(dbChirp)
+		chirp.ReplyCount = replyCountByID[dbChirp.ID]
+		chirp.LikeCount = likeCountByID[dbChirp.ID]
+		chirp.Mentions = mentionsFromDB(dbChirp.Body, mentionsByID[dbChirp.ID])
+		if likedByViewer != nil {
+			liked := likedByViewer[dbChirp.ID]
+			chirp.Liked = &liked
+		}
+		chirps = append(chirps, chirp)
+	}
+	return chirps, nil
+}
+
+// buildChirp is buildChirps for a single chirp.
+func (cfg *apiConfig) buildChirp(ctx context.Context, dbChirp database.Chirp, viewerID uuid.UUID) (Chirp, error) {
+	chirps, err := cfg.buildChirps(ctx, []database.Chirp{dbChirp}, viewerID)
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirps[0], nil
+}
+
+// optionalViewerID identifies the caller on endpoints that work without
+// authentication, accepting an access token or an API key like authenticate
+// does. It returns uuid.Nil when no Authorization header was sent. When one
+// was sent and isn't valid it responds with the error and returns false.
+func (cfg *apiConfig) optionalViewerID(w http.ResponseWriter, r *http.Request) (viewerID uuid.UUID, ok bool) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return uuid.Nil, true
+	}
+	if strings.HasPrefix(strings.ToLower(header), "apikey ") {
+		caller, ok := cfg.authenticateAPIKey(w, r)
+		return caller.UserID, ok
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err == nil {
+		viewerID, err = cfg.jwtKeys.ValidateJWT(token)
+	}
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+		return uuid.Nil, false
+	}
+	return viewerID, true
+}
+
+// resolveReferencedChirp checks that a chirp being rechirped or quoted exists.
+// Rechirps are transparent, so referencing one points at the original chirp.
+func (cfg *apiConfig) resolveReferencedChirp(ctx context.Context, chirpID uuid.UUID) (uuid.UUID, error) {
+	dbChirp, err := cfg.DB.GetChirp(ctx, chirpID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return uuid.Nil, errReferencedChirpNotFound
+		}
+		return uuid.Nil, err
+	}
+	if dbChirp.Kind != chirpKindRechirp {
+		return dbChirp.ID, nil
+	}
+	if !dbChirp.ReferencedChirpID.Valid {
+		return uuid.Nil, errReferencedChirpNotFound
+	}
+	return dbChirp.ReferencedChirpID.UUID, nil
+}
+
+// isUniqueViolation reports whether err came from a Postgres unique constraint.
+func isUniqueViolation(err error) bool {
+	_, ok := uniqueViolationConstraint(err)
+	return ok
+}
+
+// uniqueViolationConstraint is isUniqueViolation for tables with more than
+// one unique constraint, also returning which one was violated.
+func uniqueViolationConstraint(err error) (string, bool) {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != "23505" {
+		return "", false
+	}
+	return pqErr.Constraint, true
+}
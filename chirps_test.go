@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dbfletcher/chirpy/internal/auth"
+	"github.com/dbfletcher/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestChirpsGetByIDWithAPIKey(t *testing.T) {
+	const key = "chirpy_testkey"
+	ownerID := uuid.New()
+	chirpID := uuid.New()
+	now := time.Now().UTC()
+
+	tests := []struct {
+		name       string
+		header     string
+		revoked    bool
+		wantStatus int
+		wantLiked  bool
+	}{
+		{
+			name:       "NoAuthorization",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "ValidKey",
+			header:     "ApiKey " + key,
+			wantStatus: http.StatusOK,
+			wantLiked:  true,
+		},
+		{
+			name:       "UnknownKey",
+			header:     "ApiKey chirpy_otherkey",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "RevokedKey",
+			header:     "ApiKey " + key,
+			revoked:    true,
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var revokedAt any
+			if tt.revoked {
+				revokedAt = now
+			}
+			db := &fakeDB{
+				rows: map[string]fakeQuery{
+					"GetAPIKeyByHash": func(args []driver.Value) [][]driver.Value {
+						if args[0] != auth.HashToken(key) {
+							return nil
+						}
+						return [][]driver.Value{{
+							uuid.NewString(), ownerID.String(), "bot", auth.HashToken(key), "chirpy_t",
+							[]byte("{chirps:read}"), now, nil, nil, revokedAt,
+						}}
+					},
+					"GetChirp": func(args []driver.Value) [][]driver.Value {
+						return [][]driver.Value{{
+							chirpID.String(), now, now, "Hello", uuid.NewString(), nil, false, "chirp", nil,
+						}}
+					},
+					"GetLikedChirpIDs": func(args []driver.Value) [][]driver.Value {
+						if args[0] != ownerID.String() {
+							return nil
+						}
+						return [][]driver.Value{{chirpID.String()}}
+					},
+				},
+			}
+			cfg := &apiConfig{DB: database.New(sql.OpenDB(db))}
+
+			r := httptest.NewRequest("GET", "/api/chirps/"+chirpID.String(), nil)
+			r.SetPathValue("chirpID", chirpID.String())
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			cfg.handlerChirpsGetByID(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, but got %d: %s", tt.wantStatus, w.Code, w.Body)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			var chirp Chirp
+			err := json.Unmarshal(w.Body.Bytes(), &chirp)
+			if err != nil {
+				t.Fatalf("Couldn't decode response: %s", err)
+			}
+			liked := chirp.Liked != nil && *chirp.Liked
+			if liked != tt.wantLiked {
+				t.Errorf("Expected liked %v, but got %v", tt.wantLiked, liked)
+			}
+		})
+	}
+}
+
+// fakeQuery returns the rows a query produces for its arguments.
+type fakeQuery func(args []driver.Value) [][]driver.Value
+
+// fakeDB is a database/sql driver that answers the generated queries by
+// name, so handlers can be tested without Postgres. Queries it has no rows
+// for return nothing, and statements succeed without touching anything.
+type fakeDB struct {
+	rows map[string]fakeQuery
+}
+
+func (db *fakeDB) Connect(context.Context) (driver.Conn, error) { return fakeConn{db}, nil }
+func (db *fakeDB) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct{ db *fakeDB }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, driver.ErrSkip
+}
+func (c fakeConn) Close() error              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error) { return nil, driver.ErrSkip }
+
+func (c fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return driver.RowsAffected(0), nil
+}
+
+func (c fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	var rows [][]driver.Value
+	if q, ok := c.db.rows[queryName(query)]; ok {
+		rows = q(values)
+	}
+	return &fakeRows{rows: rows}, nil
+}
+
+// queryName extracts X from the "-- name: X :kind" line sqlc starts each
+// query with.
+func queryName(query string) string {
+	fields := strings.Fields(query)
+	if len(fields) < 3 || fields[1] != "name:" {
+		return ""
+	}
+	return fields[2]
+}
+
+type fakeRows struct {
+	rows [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	if len(r.rows) == 0 {
+		return nil
+	}
+	return make([]string, len(r.rows[0]))
+}
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
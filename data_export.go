@@ -0,0 +1,471 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/dbfletcher/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	dataExportStatusPending = "pending"
+	dataExportStatusReady   = "ready"
+	dataExportStatusFailed  = "failed"
+
+	// dataExportTTL is how long a finished export can be downloaded before
+	// the purge job removes it.
+	dataExportTTL = 7 * 24 * time.Hour
+	// dataExportTimeout bounds how long generating one export may take. A
+	// pending export older than this is assumed to have been abandoned.
+	dataExportTimeout = 10 * time.Minute
+)
+
+// dataExportDocument is everything we store about a user, except for
+// credentials: the password hash, the TOTP secret and the hashes of refresh
+// tokens, recovery codes, API keys and app secrets. Those would let whoever
+// gets hold of the archive sign in as the user, so sessions, 2FA, API keys
+// and apps are described by their metadata only. Verification, password
+// reset and MFA challenge tokens are left out too; they expire within hours
+// and say nothing about the user that the rest of the export doesn't.
+type dataExportDocument struct {
+	ExportedAt    time.Time                `json:"exported_at"`
+	Profile       User                     `json:"profile"`
+	ChirpyRed     bool                     `json:"chirpy_red"`
+	Chirps        []dataExportChirp        `json:"chirps"`
+	Likes         []dataExportLike         `json:"likes"`
+	Following     []dataExportFollow       `json:"following"`
+	Followers     []dataExportFollow       `json:"followers"`
+	Mentions      []dataExportMention      `json:"mentions"`
+	Notifications []dataExportNotification `json:"notifications"`
+	Sessions      []dataExportSession      `json:"sessions"`
+	TwoFactor     *dataExportTwoFactor     `json:"two_factor"`
+	APIKeys       []dataExportAPIKey       `json:"api_keys"`
+	Apps          []OAuthClient            `json:"apps"`
+}
+
+type dataExportChirp struct {
+	ID                uuid.UUID  `json:"id"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+	Body              string     `json:"body"`
+	Kind              string     `json:"kind"`
+	InReplyTo         *uuid.UUID `json:"in_reply_to"`
+	ReferencedChirpID *uuid.UUID `json:"referenced_chirp_id"`
+	// Revisions are the chirp's earlier bodies, oldest first.
+	Revisions []dataExportRevision `json:"revisions"`
+}
+
+type dataExportRevision struct {
+	Body       string    `json:"body"`
+	CreatedAt  time.Time `json:"created_at"`
+	ReplacedAt time.Time `json:"replaced_at"`
+}
+
+type dataExportLike struct {
+	ChirpID   uuid.UUID `json:"chirp_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type dataExportFollow struct {
+	UserID    uuid.UUID `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// dataExportMention is a place where someone else's chirp mentions the user.
+type dataExportMention struct {
+	ChirpID     uuid.UUID `json:"chirp_id"`
+	StartOffset int32     `json:"start"`
+	EndOffset   int32     `json:"end"`
+}
+
+type dataExportNotification struct {
+	ID        uuid.UUID  `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	Kind      string     `json:"kind"`
+	ActorID   uuid.UUID  `json:"actor_id"`
+	ChirpID   *uuid.UUID `json:"chirp_id"`
+}
+
+type dataExportSession struct {
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	LastUsedAt time.Time  `json:"last_used_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	UserAgent  string     `json:"user_agent"`
+	IPAddress  string     `json:"ip_address"`
+	// ClientID is set when the session belongs to a third-party app.
+	ClientID *uuid.UUID `json:"client_id"`
+}
+
+type dataExportTwoFactor struct {
+	EnrolledAt    time.Time                `json:"enrolled_at"`
+	ConfirmedAt   *time.Time               `json:"confirmed_at"`
+	RecoveryCodes []dataExportRecoveryCode `json:"recovery_codes"`
+}
+
+type dataExportRecoveryCode struct {
+	CreatedAt time.Time  `json:"created_at"`
+	UsedAt    *time.Time `json:"used_at"`
+}
+
+// dataExportAPIKey includes revoked keys, which APIKey responses never do.
+type dataExportAPIKey struct {
+	APIKey
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+// generateDataExport builds a requested export and stores it, marking the
+// export failed if anything goes wrong. It runs in its own goroutine, so it
+// doesn't use the request's context.
+func (cfg *apiConfig) generateDataExport(exportID, userID uuid.UUID) {
+	ctx, cancel := context.WithTimeout(context.Background(), dataExportTimeout)
+	defer cancel()
+
+	err := cfg.storeDataExport(ctx, exportID, userID)
+	if err == nil {
+		return
+	}
+	log.Printf("Error generating data export %s: %s", exportID, err)
+	err = cfg.DB.FailDataExport(context.Background(), exportID)
+	if err != nil {
+		log.Printf("Error marking data export %s as failed: %s", exportID, err)
+	}
+}
+
+func (cfg *apiConfig) storeDataExport(ctx context.Context, exportID, userID uuid.UUID) error {
+	document, err := cfg.collectDataExport(ctx, userID)
+	if err != nil {
+		return err
+	}
+	jsonData, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return err
+	}
+	zipData, err := zipDataExport(document)
+	if err != nil {
+		return err
+	}
+
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	err = qtx.CreateDataExportFile(ctx, database.CreateDataExportFileParams{
+		DataExportID: exportID,
+		JsonData:     jsonData,
+		ZipData:      zipData,
+	})
+	if err != nil {
+		return err
+	}
+	err = qtx.CompleteDataExport(ctx, exportID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (cfg *apiConfig) collectDataExport(ctx context.Context, userID uuid.UUID) (dataExportDocument, error) {
+	dbUser, err := cfg.DB.GetUser(ctx, userID)
+	if err != nil {
+		return dataExportDocument{}, err
+	}
+	document := dataExportDocument{
+		ExportedAt: time.Now().UTC(),
+		Profile:    userFromDB(dbUser),
+		ChirpyRed:  dbUser.IsChirpyRed,
+	}
+
+	document.Chirps, err = cfg.collectDataExportChirps(ctx, userID)
+	if err != nil {
+		return dataExportDocument{}, err
+	}
+	document.Likes, document.Following, document.Followers, err = cfg.collectDataExportActivity(ctx, userID)
+	if err != nil {
+		return dataExportDocument{}, err
+	}
+	document.Mentions, document.Notifications, err = cfg.collectDataExportNotifications(ctx, userID)
+	if err != nil {
+		return dataExportDocument{}, err
+	}
+	document.Sessions, err = cfg.collectDataExportSessions(ctx, userID)
+	if err != nil {
+		return dataExportDocument{}, err
+	}
+	document.TwoFactor, err = cfg.collectDataExportTwoFactor(ctx, userID)
+	if err != nil {
+		return dataExportDocument{}, err
+	}
+	document.APIKeys, document.Apps, err = cfg.collectDataExportIntegrations(ctx, userID)
+	if err != nil {
+		return dataExportDocument{}, err
+	}
+	return document, nil
+}
+
+func (cfg *apiConfig) collectDataExportChirps(ctx context.Context, userID uuid.UUID) ([]dataExportChirp, error) {
+	dbChirps, err := cfg.DB.GetChirpsByAuthor(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	dbRevisions, err := cfg.DB.GetChirpRevisionsByAuthor(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := make(map[uuid.UUID][]dataExportRevision)
+	for _, dbRevision := range dbRevisions {
+		revisions[dbRevision.ChirpID] = append(revisions[dbRevision.ChirpID], dataExportRevision{
+			Body:       dbRevision.Body,
+			CreatedAt:  dbRevision.CreatedAt,
+			ReplacedAt: dbRevision.ReplacedAt,
+		})
+	}
+
+	chirps := make([]dataExportChirp, 0, len(dbChirps))
+	for _, dbChirp := range dbChirps {
+		chirp := dataExportChirp{
+			ID:        dbChirp.ID,
+			CreatedAt: dbChirp.CreatedAt,
+			UpdatedAt: dbChirp.UpdatedAt,
+			Body:      dbChirp.Body,
+			Kind:      dbChirp.Kind,
+			Revisions: revisions[dbChirp.ID],
+		}
+		if chirp.Revisions == nil {
+			chirp.Revisions = []dataExportRevision{}
+		}
+		if dbChirp.InReplyTo.Valid {
+			inReplyTo := dbChirp.InReplyTo.UUID
+			chirp.InReplyTo = &inReplyTo
+		}
+		if dbChirp.ReferencedChirpID.Valid {
+			referencedChirpID := dbChirp.ReferencedChirpID.UUID
+			chirp.ReferencedChirpID = &referencedChirpID
+		}
+		chirps = append(chirps, chirp)
+	}
+	return chirps, nil
+}
+
+func (cfg *apiConfig) collectDataExportActivity(ctx context.Context, userID uuid.UUID) ([]dataExportLike, []dataExportFollow, []dataExportFollow, error) {
+	dbLikes, err := cfg.DB.GetLikesByUser(ctx, userID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	dbFollowing, err := cfg.DB.GetFollowsByFollower(ctx, userID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	dbFollowers, err := cfg.DB.GetFollowsByFollowee(ctx, userID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	likes := make([]dataExportLike, 0, len(dbLikes))
+	for _, dbLike := range dbLikes {
+		likes = append(likes, dataExportLike{
+			ChirpID:   dbLike.ChirpID,
+			CreatedAt: dbLike.CreatedAt,
+		})
+	}
+	following := make([]dataExportFollow, 0, len(dbFollowing))
+	for _, dbFollow := range dbFollowing {
+		following = append(following, dataExportFollow{
+			UserID:    dbFollow.FolloweeID,
+			CreatedAt: dbFollow.CreatedAt,
+		})
+	}
+	followers := make([]dataExportFollow, 0, len(dbFollowers))
+	for _, dbFollow := range dbFollowers {
+		followers = append(followers, dataExportFollow{
+			UserID:    dbFollow.FollowerID,
+			CreatedAt: dbFollow.CreatedAt,
+		})
+	}
+	return likes, following, followers, nil
+}
+
+func (cfg *apiConfig) collectDataExportNotifications(ctx context.Context, userID uuid.UUID) ([]dataExportMention, []dataExportNotification, error) {
+	dbMentions, err := cfg.DB.GetMentionsOfUser(ctx, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	dbNotifications, err := cfg.DB.GetAllNotificationsForUser(ctx, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mentions := make([]dataExportMention, 0, len(dbMentions))
+	for _, dbMention := range dbMentions {
+		mentions = append(mentions, dataExportMention{
+			ChirpID:     dbMention.ChirpID,
+			StartOffset: dbMention.StartOffset,
+			EndOffset:   dbMention.EndOffset,
+		})
+	}
+	notifications := make([]dataExportNotification, 0, len(dbNotifications))
+	for _, dbNotification := range dbNotifications {
+		notification := dataExportNotification{
+			ID:        dbNotification.ID,
+			CreatedAt: dbNotification.CreatedAt,
+			Kind:      dbNotification.Kind,
+			ActorID:   dbNotification.ActorID,
+		}
+		if dbNotification.ChirpID.Valid {
+			chirpID := dbNotification.ChirpID.UUID
+			notification.ChirpID = &chirpID
+		}
+		notifications = append(notifications, notification)
+	}
+	return mentions, notifications, nil
+}
+
+func (cfg *apiConfig) collectDataExportSessions(ctx context.Context, userID uuid.UUID) ([]dataExportSession, error) {
+	dbRefreshTokens, err := cfg.DB.GetRefreshTokensForUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]dataExportSession, 0, len(dbRefreshTokens))
+	for _, dbRefreshToken := range dbRefreshTokens {
+		session := dataExportSession{
+			CreatedAt:  dbRefreshToken.CreatedAt,
+			UpdatedAt:  dbRefreshToken.UpdatedAt,
+			LastUsedAt: dbRefreshToken.LastUsedAt,
+			ExpiresAt:  dbRefreshToken.ExpiresAt,
+			UserAgent:  dbRefreshToken.UserAgent,
+			IPAddress:  dbRefreshToken.IpAddress,
+		}
+		if dbRefreshToken.RevokedAt.Valid {
+			revokedAt := dbRefreshToken.RevokedAt.Time
+			session.RevokedAt = &revokedAt
+		}
+		if dbRefreshToken.ClientID.Valid {
+			clientID := dbRefreshToken.ClientID.UUID
+			session.ClientID = &clientID
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+// collectDataExportTwoFactor returns nil if the user never set up 2FA.
+func (cfg *apiConfig) collectDataExportTwoFactor(ctx context.Context, userID uuid.UUID) (*dataExportTwoFactor, error) {
+	credential, err := cfg.DB.GetTOTPCredential(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	dbRecoveryCodes, err := cfg.DB.GetRecoveryCodesForUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	twoFactor := &dataExportTwoFactor{
+		EnrolledAt:    credential.CreatedAt,
+		RecoveryCodes: make([]dataExportRecoveryCode, 0, len(dbRecoveryCodes)),
+	}
+	if credential.ConfirmedAt.Valid {
+		confirmedAt := credential.ConfirmedAt.Time
+		twoFactor.ConfirmedAt = &confirmedAt
+	}
+	for _, dbRecoveryCode := range dbRecoveryCodes {
+		recoveryCode := dataExportRecoveryCode{CreatedAt: dbRecoveryCode.CreatedAt}
+		if dbRecoveryCode.UsedAt.Valid {
+			usedAt := dbRecoveryCode.UsedAt.Time
+			recoveryCode.UsedAt = &usedAt
+		}
+		twoFactor.RecoveryCodes = append(twoFactor.RecoveryCodes, recoveryCode)
+	}
+	return twoFactor, nil
+}
+
+func (cfg *apiConfig) collectDataExportIntegrations(ctx context.Context, userID uuid.UUID) ([]dataExportAPIKey, []OAuthClient, error) {
+	dbKeys, err := cfg.DB.GetAllAPIKeysForUser(ctx, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	dbClients, err := cfg.DB.GetOAuthClientsForUser(ctx, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keys := make([]dataExportAPIKey, 0, len(dbKeys))
+	for _, dbKey := range dbKeys {
+		key := dataExportAPIKey{APIKey: apiKeyFromDB(dbKey)}
+		if dbKey.RevokedAt.Valid {
+			revokedAt := dbKey.RevokedAt.Time
+			key.RevokedAt = &revokedAt
+		}
+		keys = append(keys, key)
+	}
+	clients := make([]OAuthClient, 0, len(dbClients))
+	for _, dbClient := range dbClients {
+		clients = append(clients, oauthClientFromDB(dbClient))
+	}
+	return keys, clients, nil
+}
+
+// zipDataExport splits an export into one JSON file per section so the
+// archive is easy to browse.
+func zipDataExport(document dataExportDocument) ([]byte, error) {
+	files := []struct {
+		name    string
+		content any
+	}{
+		{"profile.json", struct {
+			Profile   User `json:"profile"`
+			ChirpyRed bool `json:"chirpy_red"`
+		}{document.Profile, document.ChirpyRed}},
+		{"chirps.json", document.Chirps},
+		{"likes.json", document.Likes},
+		{"following.json", document.Following},
+		{"followers.json", document.Followers},
+		{"mentions.json", document.Mentions},
+		{"notifications.json", document.Notifications},
+		{"sessions.json", document.Sessions},
+		{"two_factor.json", document.TwoFactor},
+		{"api_keys.json", document.APIKeys},
+		{"apps.json", document.Apps},
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, file := range files {
+		data, err := json.MarshalIndent(file.content, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     file.name,
+			Method:   zip.Deflate,
+			Modified: document.ExportedAt,
+		})
+		if err != nil {
+			return nil, err
+		}
+		_, err = fw.Write(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := zw.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	netmail "net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dbfletcher/chirpy/internal/auth"
+	"github.com/dbfletcher/chirpy/internal/database"
+	"github.com/dbfletcher/chirpy/internal/mail"
+)
+
+const emailVerificationTTL = 24 * time.Hour
+
+const (
+	// Outgoing mail is delivered by mailWorkers goroutines, each message
+	// getting mailSendTimeout, with up to mailQueueSize waiting.
+	mailWorkers     = 4
+	mailQueueSize   = 100
+	mailSendTimeout = 30 * time.Second
+
+	// maxMailsPerAddress is how many emails of one kind the endpoints that
+	// don't need a login will send to an address per mailLimitWindow.
+	maxMailsPerAddress = 3
+	mailLimitWindow    = time.Hour
+	// maxMailRequests bounds how many of those requests are looked up at
+	// once.
+	maxMailRequests = 16
+)
+
+// validateEmail accepts a bare address like user@example.com, without a
+// display name or angle brackets.
+func validateEmail(email string) error {
+	addr, err := netmail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return errors.New("invalid email address")
+	}
+	return nil
+}
+
+// mailSenderFromEnv picks how outgoing mail is delivered. SMTP is used when
+// SMTP_HOST is set. On the dev platform messages can instead be written to
+// files in MAIL_DIR so verification links can be followed locally; anywhere
+// else a missing SMTP_HOST is a configuration error, since mail would
+// silently never arrive.
+func mailSenderFromEnv(platform, from string) mail.Sender {
+	if host := os.Getenv("SMTP_HOST"); host != "" {
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		return &mail.SMTPSender{
+			Host:     host,
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		}
+	}
+
+	if platform != "dev" {
+		log.Fatal("SMTP_HOST environment variable is not set")
+	}
+	dir := os.Getenv("MAIL_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "chirpy-mail")
+	}
+	log.Printf("SMTP_HOST is not set, writing outgoing mail to %s", dir)
+	return &mail.FileSender{Dir: dir, From: from}
+}
+
+// sendEmailVerification emails the user a link that confirms they own their
+// current address. Only a hash of the token is stored.
+func (cfg *apiConfig) sendEmailVerification(ctx context.Context, user database.User) error {
+	token, err := auth.MakeToken()
+	if err != nil {
+		return err
+	}
+
+	err = cfg.DB.CreateEmailVerificationToken(ctx, database.CreateEmailVerificationTokenParams{
+		TokenHash: auth.HashToken(token),
+		UserID:    user.ID,
+		Email:     user.Email,
+		ExpiresAt: time.Now().UTC().Add(emailVerificationTTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/api/verify?token=%s", cfg.baseURL, token)
+	return cfg.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Verify your Chirpy email address",
+		Body: fmt.Sprintf("Welcome to Chirpy!\n\nConfirm your email address by opening this link within %d hours:\n\n%s\n\nIf you didn't sign up for Chirpy, you can ignore this email.\n",
+			int(emailVerificationTTL.Hours()), link),
+	})
+}
+
+// startMailRequest handles a request, from someone who needn't be logged
+// in, to email address, by running send in the background. The request is
+// dropped if the address has been sent too many emails of this kind lately
+// or too many requests are already in progress. Callers answer the same
+// either way, so nothing shows whether an email went out.
+func (cfg *apiConfig) startMailRequest(kind, address string, send func(ctx context.Context, address string)) {
+	if !cfg.mailLimiter.Allow(kind+":"+strings.ToLower(address), time.Now()) {
+		return
+	}
+	select {
+	case cfg.mailRequests <- struct{}{}:
+	default:
+		log.Printf("Dropping %s email request: too many in progress", kind)
+		return
+	}
+
+	go func() {
+		defer func() { <-cfg.mailRequests }()
+		ctx, cancel := context.WithTimeout(context.Background(), mailSendTimeout)
+		defer cancel()
+		send(ctx, address)
+	}()
+}
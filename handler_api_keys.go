@@ -0,0 +1,176 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dbfletcher/chirpy/internal/auth"
+	"github.com/dbfletcher/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const maxAPIKeyNameLength = 100
+
+type APIKey struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+func apiKeyFromDB(dbKey database.ApiKey) APIKey {
+	key := APIKey{
+		ID:        dbKey.ID,
+		Name:      dbKey.Name,
+		Prefix:    dbKey.Prefix,
+		Scopes:    dbKey.Scopes,
+		CreatedAt: dbKey.CreatedAt,
+	}
+	if dbKey.ExpiresAt.Valid {
+		key.ExpiresAt = &dbKey.ExpiresAt.Time
+	}
+	if dbKey.LastUsedAt.Valid {
+		key.LastUsedAt = &dbKey.LastUsedAt.Time
+	}
+	return key
+}
+
+// handlerAPIKeysCreate issues a personal API key. The key itself is only
+// ever returned here; afterwards only its prefix is shown.
+func (cfg *apiConfig) handlerAPIKeysCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	type response struct {
+		APIKey
+		Key string `json:"key"`
+	}
+
+	userID := authenticatedUserID(r)
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	name := strings.TrimSpace(params.Name)
+	if name == "" || len(name) > maxAPIKeyNameLength {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("name must be between 1 and %d characters", maxAPIKeyNameLength))
+		return
+	}
+	err = validateAPIKeyScopes(r, params.Scopes)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	expiresAt := sql.NullTime{}
+	if params.ExpiresAt != nil {
+		if !params.ExpiresAt.After(time.Now()) {
+			respondWithError(w, http.StatusBadRequest, "expires_at must be in the future")
+			return
+		}
+		expiresAt = sql.NullTime{Time: params.ExpiresAt.UTC(), Valid: true}
+	}
+
+	key, err := auth.MakeAPIKey()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create API key")
+		return
+	}
+
+	dbKey, err := cfg.DB.CreateAPIKey(r.Context(), database.CreateAPIKeyParams{
+		UserID:    userID,
+		Name:      name,
+		KeyHash:   auth.HashToken(key),
+		Prefix:    auth.APIKeyPrefix(key),
+		Scopes:    params.Scopes,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		log.Printf("Error creating API key for user %s: %s", userID, err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create API key")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, response{
+		APIKey: apiKeyFromDB(dbKey),
+		Key:    key,
+	})
+}
+
+// validateAPIKeyScopes checks the scopes asked for a new key. A key can't
+// be given a scope the caller's own token doesn't have.
+func validateAPIKeyScopes(r *http.Request, scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("scopes must include at least one of %s", strings.Join(delegatedScopes, ", "))
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(delegatedScopes, scope) {
+			return fmt.Errorf("API keys can't have the %q scope", scope)
+		}
+		if !hasScope(r, scope) {
+			return fmt.Errorf("token is missing the %s scope", scope)
+		}
+	}
+	return nil
+}
+
+// handlerAPIKeysGet lists the caller's API keys that haven't been revoked,
+// newest first. Expired keys are included so they can be recognised.
+func (cfg *apiConfig) handlerAPIKeysGet(w http.ResponseWriter, r *http.Request) {
+	userID := authenticatedUserID(r)
+
+	dbKeys, err := cfg.DB.GetAPIKeysForUser(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error getting API keys of user %s: %s", userID, err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve API keys")
+		return
+	}
+
+	keys := []APIKey{}
+	for _, dbKey := range dbKeys {
+		keys = append(keys, apiKeyFromDB(dbKey))
+	}
+
+	respondWithJSON(w, http.StatusOK, keys)
+}
+
+// handlerAPIKeysDelete revokes one of the caller's API keys.
+func (cfg *apiConfig) handlerAPIKeysDelete(w http.ResponseWriter, r *http.Request) {
+	userID := authenticatedUserID(r)
+
+	keyID, err := uuid.Parse(r.PathValue("keyID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid API key ID")
+		return
+	}
+
+	revoked, err := cfg.DB.RevokeAPIKey(r.Context(), database.RevokeAPIKeyParams{
+		ID:     keyID,
+		UserID: userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke API key")
+		return
+	}
+	if revoked == 0 {
+		respondWithError(w, http.StatusNotFound, "API key not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dbfletcher/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+type ChirpRevision struct {
+	Body       string    `json:"body"`
+	CreatedAt  time.Time `json:"created_at"`
+	ReplacedAt time.Time `json:"replaced_at"`
+}
+
+func (cfg *apiConfig) handlerChirpsUpdate(w http.ResponseWriter, r *http.Request) {
+	userID := authenticatedUserID(r)
+
+	chirpIDStr := r.PathValue("chirpID")
+	chirpID, err := uuid.Parse(chirpIDStr)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Chirp ID")
+		return
+	}
+
+	type parameters struct {
+		Body string `json:"body"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if len(params.Body) > maxChirpLength {
+		respondWithError(w, http.StatusBadRequest, "Chirp is too long")
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update chirp")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	// Lock the row so concurrent edits can't both record the same prior body.
+	dbChirp, err := qtx.GetChirpForUpdate(r.Context(), chirpID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusNotFound, "Chirp not found")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chirp")
+		return
+	}
+
+	if dbChirp.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't edit this chirp")
+		return
+	}
+	if dbChirp.Kind == chirpKindRechirp {
+		respondWithError(w, http.StatusBadRequest, "Rechirps can't be edited")
+		return
+	}
+	if dbChirp.Kind == chirpKindQuote && params.Body == "" {
+		respondWithError(w, http.StatusBadRequest, "A quote needs a body")
+		return
+	}
+
+	cleanedBody := cleanProfanity(params.Body)
+	if cleanedBody != dbChirp.Body {
+		err = qtx.CreateChirpRevision(r.Context(), database.CreateChirpRevisionParams{
+			ChirpID:   dbChirp.ID,
+			Body:      dbChirp.Body,
+			CreatedAt: dbChirp.UpdatedAt,
+		})
+		if err != nil {
+			log.Printf("Error saving revision of chirp %s: %s", dbChirp.ID, err)
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update chirp")
+			return
+		}
+
+		dbChirp, err = qtx.UpdateChirpBody(r.Context(), database.UpdateChirpBodyParams{
+			ID:   dbChirp.ID,
+			Body: cleanedBody,
+		})
+		if err != nil {
+			log.Printf("Error updating chirp %s: %s", chirpID, err)
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update chirp")
+			return
+		}
+
+		err = indexChirpTags(r.Context(), qtx, dbChirp)
+		if err != nil {
+			log.Printf("Error indexing tags of chirp %s: %s", chirpID, err)
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update chirp")
+			return
+		}
+
+		err = indexChirpMentions(r.Context(), qtx, dbChirp)
+		if err != nil {
+			log.Printf("Error indexing mentions of chirp %s: %s", chirpID, err)
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update chirp")
+			return
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update chirp")
+		return
+	}
+
+	chirp, err := cfg.buildChirp(r.Context(), dbChirp, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chirp")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, chirp)
+}
+
+// handlerChirpsRevisionsGet lists the earlier bodies of a chirp, oldest first.
+// The current body is the chirp itself.
+func (cfg *apiConfig) handlerChirpsRevisionsGet(w http.ResponseWriter, r *http.Request) {
+	chirpIDStr := r.PathValue("chirpID")
+	chirpID, err := uuid.Parse(chirpIDStr)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Chirp ID")
+		return
+	}
+
+	_, err = cfg.DB.GetChirp(r.Context(), chirpID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusNotFound, "Chirp not found")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chirp")
+		return
+	}
+
+	dbRevisions, err := cfg.DB.GetChirpRevisions(r.Context(), chirpID)
+	if err != nil {
+		log.Printf("Error getting revisions of chirp %s: %s", chirpID, err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve revisions")
+		return
+	}
+
+	revisions := []ChirpRevision{}
+	for _, dbRevision := range dbRevisions {
+		revisions = append(revisions, ChirpRevision{
+			Body:       dbRevision.Body,
+			CreatedAt:  dbRevision.CreatedAt,
+			ReplacedAt: dbRevision.ReplacedAt,
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, revisions)
+}
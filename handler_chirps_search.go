@@ -0,0 +1,121 @@
+package main
+
+import (
+	"html"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/dbfletcher/chirpy/internal/database"
+)
+
+type ChirpSearchResult struct {
+	Chirp
+	Rank      float32 `json:"rank"`
+	Highlight string  `json:"highlight"`
+}
+
+func (cfg *apiConfig) handlerChirpsSearch(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Results    []ChirpSearchResult `json:"results"`
+		NextOffset *int32              `json:"next_offset,omitempty"`
+	}
+	const maxQueryLength = 256
+
+	viewerID, ok := cfg.optionalViewerID(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	searchQuery := strings.TrimSpace(query.Get("q"))
+	if searchQuery == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing search query")
+		return
+	}
+	if len(searchQuery) > maxQueryLength {
+		respondWithError(w, http.StatusBadRequest, "Search query is too long")
+		return
+	}
+
+	limit, err := parseLimit(query)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	offset, err := parseOffset(query)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// websearch_to_tsquery understands "quoted phrases", OR and -exclusions.
+	rows, err := cfg.DB.SearchChirps(r.Context(), database.SearchChirpsParams{
+		Query:  searchQuery,
+		Limit:  limit + 1,
+		Offset: offset,
+	})
+	if err != nil {
+		log.Printf("Error searching chirps: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't search chirps")
+		return
+	}
+
+	resp := response{Results: []ChirpSearchResult{}}
+	if len(rows) > int(limit) {
+		rows = rows[:limit]
+		nextOffset := offset + limit
+		resp.NextOffset = &nextOffset
+	}
+
+	dbChirps := make([]database.Chirp, 0, len(rows))
+	for _, row := range rows {
+		dbChirps = append(dbChirps, row.Chirp)
+	}
+	chirps, err := cfg.buildChirps(r.Context(), dbChirps, viewerID)
+	if err != nil {
+		log.Printf("Error building chirps: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't search chirps")
+		return
+	}
+
+	for i, row := range rows {
+		resp.Results = append(resp.Results, ChirpSearchResult{
+			Chirp:     chirps[i],
+			Rank:      row.Rank,
+			Highlight: highlightHTML(row.Headline),
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// highlightHTML turns a ts_headline result into safe HTML. Postgres does not
+// escape the chirp body, so everything between the <mark> delimiters is
+// escaped here and only our own tags survive.
+func highlightHTML(headline string) string {
+	const startSel = "<mark>"
+	const stopSel = "</mark>"
+
+	var sb strings.Builder
+	open := false
+	for headline != "" {
+		delim := stopSel
+		if !open {
+			delim = startSel
+		}
+		i := strings.Index(headline, delim)
+		if i < 0 {
+			sb.WriteString(html.EscapeString(headline))
+			break
+		}
+		sb.WriteString(html.EscapeString(headline[:i]))
+		sb.WriteString(delim)
+		headline = headline[i+len(delim):]
+		open = !open
+	}
+	if open {
+		sb.WriteString(stopSel)
+	}
+	return sb.String()
+}
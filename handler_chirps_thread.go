@@ -0,0 +1,145 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/dbfletcher/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultThreadDepth = 3
+	maxThreadDepth     = 10
+	maxThreadAncestors = 50
+)
+
+type ChirpThreadNode struct {
+	Chirp
+	Replies []ChirpThreadNode `json:"replies"`
+}
+
+func (cfg *apiConfig) handlerChirpsThread(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Ancestors []Chirp `json:"ancestors"`
+		// AncestorsTruncated is set when the chain goes further up than we
+		// were willing to walk; the first ancestor's in_reply_to continues it.
+		AncestorsTruncated bool `json:"ancestors_truncated"`
+		// AncestorDeleted is set when the chain starts with a reply to a
+		// chirp that has since been deleted, which clients show as a
+		// placeholder above the first ancestor.
+		AncestorDeleted bool            `json:"ancestor_deleted"`
+		Chirp           ChirpThreadNode `json:"chirp"`
+	}
+
+	viewerID, ok := cfg.optionalViewerID(w, r)
+	if !ok {
+		return
+	}
+
+	chirpIDStr := r.PathValue("chirpID")
+	chirpID, err := uuid.Parse(chirpIDStr)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Chirp ID")
+		return
+	}
+
+	depth := defaultThreadDepth
+	if depthStr := r.URL.Query().Get("depth"); depthStr != "" {
+		depth, err = strconv.Atoi(depthStr)
+		if err != nil || depth < 0 || depth > maxThreadDepth {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("depth must be between 0 and %d", maxThreadDepth))
+			return
+		}
+	}
+
+	dbChirp, err := cfg.DB.GetChirp(r.Context(), chirpID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusNotFound, "Chirp not found")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chirp")
+		return
+	}
+
+	// Deleting a chirp detaches its replies (in_reply_to is set to NULL), so
+	// the ancestor chain ends at the oldest chirp that still exists, which
+	// is marked with parent_deleted. The walk also stops at chirps by users
+	// whose accounts are being deleted.
+	dbAncestors, err := cfg.DB.GetChirpAncestors(r.Context(), database.GetChirpAncestorsParams{
+		ID:       chirpID,
+		MaxDepth: maxThreadAncestors,
+	})
+	if err != nil {
+		log.Printf("Error getting ancestors of chirp %s: %s", chirpID, err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve thread")
+		return
+	}
+
+	dbDescendants := []database.Chirp{}
+	if depth > 0 {
+		dbDescendants, err = cfg.DB.GetChirpDescendants(r.Context(), database.GetChirpDescendantsParams{
+			ID:       chirpID,
+			MaxDepth: int32(depth),
+		})
+		if err != nil {
+			log.Printf("Error getting replies to chirp %s: %s", chirpID, err)
+			respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve thread")
+			return
+		}
+	}
+
+	dbThread := append(append(dbAncestors, dbChirp), dbDescendants...)
+	thread, err := cfg.buildChirps(r.Context(), dbThread, viewerID)
+	if err != nil {
+		log.Printf("Error building thread for chirp %s: %s", chirpID, err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve thread")
+		return
+	}
+	ancestors := thread[:len(dbAncestors)]
+	root := thread[len(dbAncestors)]
+	descendants := thread[len(dbAncestors)+1:]
+
+	// Descendants arrive oldest first, so each reply list stays chronological.
+	repliesByParent := map[uuid.UUID][]Chirp{}
+	for _, reply := range descendants {
+		if reply.InReplyTo == nil {
+			continue
+		}
+		repliesByParent[*reply.InReplyTo] = append(repliesByParent[*reply.InReplyTo], reply)
+	}
+
+	resp := response{
+		Ancestors: ancestors,
+		Chirp:     buildThreadNode(root, repliesByParent),
+	}
+	if len(ancestors) == maxThreadAncestors && ancestors[0].InReplyTo != nil {
+		resp.AncestorsTruncated = true
+	}
+	oldest := root
+	if len(ancestors) > 0 {
+		oldest = ancestors[0]
+	}
+	// A parent that is still referenced but wasn't returned belongs to a
+	// deleted account, and is shown the same way as a deleted chirp.
+	resp.AncestorDeleted = oldest.ParentDeleted || (oldest.InReplyTo != nil && !resp.AncestorsTruncated)
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// buildThreadNode nests the replies beneath a chirp. Chirps at the depth
+// limit keep their reply_count but come back with no replies loaded.
+func buildThreadNode(chirp Chirp, repliesByParent map[uuid.UUID][]Chirp) ChirpThreadNode {
+	node := ChirpThreadNode{
+		Chirp:   chirp,
+		Replies: []ChirpThreadNode{},
+	}
+	for _, reply := range repliesByParent[chirp.ID] {
+		node.Replies = append(node.Replies, buildThreadNode(reply, repliesByParent))
+	}
+	return node
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dbfletcher/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+type DataExport struct {
+	ID          uuid.UUID  `json:"id"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	// DownloadURL is only set once the export is ready.
+	DownloadURL string `json:"download_url,omitempty"`
+}
+
+func dataExportFromDB(dbExport database.DataExport) DataExport {
+	export := DataExport{
+		ID:        dbExport.ID,
+		Status:    dbExport.Status,
+		CreatedAt: dbExport.CreatedAt,
+		ExpiresAt: dbExport.ExpiresAt,
+	}
+	if dbExport.CompletedAt.Valid {
+		completedAt := dbExport.CompletedAt.Time
+		export.CompletedAt = &completedAt
+	}
+	if dbExport.Status == dataExportStatusReady {
+		export.DownloadURL = fmt.Sprintf("/api/exports/%s/download", dbExport.ID)
+	}
+	return export
+}
+
+// handlerDataExportCreate starts generating an archive of the caller's data
+// and returns straight away. Asking again while an export is still being
+// generated returns that export instead of starting another.
+func (cfg *apiConfig) handlerDataExportCreate(w http.ResponseWriter, r *http.Request) {
+	userID := authenticatedUserID(r)
+
+	dbExport, err := cfg.DB.GetPendingDataExport(r.Context(), database.GetPendingDataExportParams{
+		UserID:       userID,
+		CreatedAfter: time.Now().UTC().Add(-dataExportTimeout),
+	})
+	if err == nil {
+		w.Header().Set("Location", fmt.Sprintf("/api/exports/%s", dbExport.ID))
+		respondWithJSON(w, http.StatusAccepted, dataExportFromDB(dbExport))
+		return
+	}
+	if err != sql.ErrNoRows {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create export")
+		return
+	}
+
+	dbExport, err = cfg.DB.CreateDataExport(r.Context(), database.CreateDataExportParams{
+		UserID:    userID,
+		ExpiresAt: time.Now().UTC().Add(dataExportTTL),
+	})
+	if err != nil {
+		log.Printf("Error creating data export: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create export")
+		return
+	}
+
+	go cfg.generateDataExport(dbExport.ID, userID)
+
+	w.Header().Set("Location", fmt.Sprintf("/api/exports/%s", dbExport.ID))
+	respondWithJSON(w, http.StatusAccepted, dataExportFromDB(dbExport))
+}
+
+func (cfg *apiConfig) handlerDataExportGet(w http.ResponseWriter, r *http.Request) {
+	dbExport, ok := cfg.getDataExportForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, dataExportFromDB(dbExport))
+}
+
+// handlerDataExportDownload serves a finished export as a zip archive, or as
+// a single JSON document with ?format=json.
+func (cfg *apiConfig) handlerDataExportDownload(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "zip"
+	}
+	if format != "zip" && format != "json" {
+		respondWithError(w, http.StatusBadRequest, "format must be zip or json")
+		return
+	}
+
+	dbExport, ok := cfg.getDataExportForRequest(w, r)
+	if !ok {
+		return
+	}
+	if dbExport.Status != dataExportStatusReady {
+		respondWithError(w, http.StatusConflict, fmt.Sprintf("Export is %s", dbExport.Status))
+		return
+	}
+
+	file, err := cfg.DB.GetDataExportFile(r.Context(), dbExport.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve export")
+		return
+	}
+
+	filename := fmt.Sprintf("chirpy-export-%s.%s", dbExport.CreatedAt.Format("2006-01-02"), format)
+	data := file.ZipData
+	contentType := "application/zip"
+	if format == "json" {
+		data = file.JsonData
+		contentType = "application/json"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// getDataExportForRequest loads the caller's export in the path, writing an
+// error response if it can't. Other users' exports are reported as not found.
+func (cfg *apiConfig) getDataExportForRequest(w http.ResponseWriter, r *http.Request) (database.DataExport, bool) {
+	userID := authenticatedUserID(r)
+
+	exportID, err := uuid.Parse(r.PathValue("exportID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid export ID")
+		return database.DataExport{}, false
+	}
+
+	dbExport, err := cfg.DB.GetDataExport(r.Context(), database.GetDataExportParams{
+		ID:     exportID,
+		UserID: userID,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusNotFound, "Export not found")
+			return database.DataExport{}, false
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve export")
+		return database.DataExport{}, false
+	}
+	return dbExport, true
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dbfletcher/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+type Follow struct {
+	UserID     uuid.UUID `json:"user_id"`
+	FollowedAt time.Time `json:"followed_at"`
+}
+
+func (cfg *apiConfig) handlerFollowCreate(w http.ResponseWriter, r *http.Request) {
+	userID := authenticatedUserID(r)
+
+	followeeIDStr := r.PathValue("userID")
+	followeeID, err := uuid.Parse(followeeIDStr)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	if followeeID == userID {
+		respondWithError(w, http.StatusBadRequest, "You can't follow yourself")
+		return
+	}
+
+	_, err = cfg.DB.GetUser(r.Context(), followeeID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve user")
+		return
+	}
+
+	// Following someone you already follow is a no-op.
+	err = cfg.DB.FollowUser(r.Context(), database.FollowUserParams{
+		FollowerID: userID,
+		FolloweeID: followeeID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't follow user")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerFollowDelete(w http.ResponseWriter, r *http.Request) {
+	userID := authenticatedUserID(r)
+
+	followeeIDStr := r.PathValue("userID")
+	followeeID, err := uuid.Parse(followeeIDStr)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	err = cfg.DB.UnfollowUser(r.Context(), database.UnfollowUserParams{
+		FollowerID: userID,
+		FolloweeID: followeeID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't unfollow user")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerFollowersGet(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Count     int64    `json:"count"`
+		Followers []Follow `json:"followers"`
+	}
+
+	userID, limit, offset, ok := cfg.parseFollowListRequest(w, r)
+	if !ok {
+		return
+	}
+
+	count, err := cfg.DB.CountFollowers(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error counting followers: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve followers")
+		return
+	}
+	dbFollows, err := cfg.DB.GetFollowers(r.Context(), database.GetFollowersParams{
+		FolloweeID: userID,
+		Limit:      limit,
+		Offset:     offset,
+	})
+	if err != nil {
+		log.Printf("Error getting followers: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve followers")
+		return
+	}
+
+	followers := []Follow{}
+	for _, dbFollow := range dbFollows {
+		followers = append(followers, Follow{
+			UserID:     dbFollow.FollowerID,
+			FollowedAt: dbFollow.CreatedAt,
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Count:     count,
+		Followers: followers,
+	})
+}
+
+func (cfg *apiConfig) handlerFollowingGet(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Count     int64    `json:"count"`
+		Following []Follow `json:"following"`
+	}
+
+	userID, limit, offset, ok := cfg.parseFollowListRequest(w, r)
+	if !ok {
+		return
+	}
+
+	count, err := cfg.DB.CountFollowing(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error counting followed users: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve followed users")
+		return
+	}
+	dbFollows, err := cfg.DB.GetFollowing(r.Context(), database.GetFollowingParams{
+		FollowerID: userID,
+		Limit:      limit,
+		Offset:     offset,
+	})
+	if err != nil {
+		log.Printf("Error getting followed users: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve followed users")
+		return
+	}
+
+	following := []Follow{}
+	for _, dbFollow := range dbFollows {
+		following = append(following, Follow{
+			UserID:     dbFollow.FolloweeID,
+			FollowedAt: dbFollow.CreatedAt,
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Count:     count,
+		Following: following,
+	})
+}
+
+// parseFollowListRequest reads the user ID and paging parameters shared by
+// the follower and following listings, writing an error response if they
+// are invalid.
+func (cfg *apiConfig) parseFollowListRequest(w http.ResponseWriter, r *http.Request) (uuid.UUID, int32, int32, bool) {
+	userIDStr := r.PathValue("userID")
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return uuid.Nil, 0, 0, false
+	}
+
+	query := r.URL.Query()
+	limit, err := parseLimit(query)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return uuid.Nil, 0, 0, false
+	}
+	offset, err := parseOffset(query)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return uuid.Nil, 0, 0, false
+	}
+
+	_, err = cfg.DB.GetUser(r.Context(), userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusNotFound, "User not found")
+			return uuid.Nil, 0, 0, false
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve user")
+		return uuid.Nil, 0, 0, false
+	}
+
+	return userID, limit, offset, true
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dbfletcher/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+type Notification struct {
+	ID        uuid.UUID  `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	Kind      string     `json:"kind"`
+	ActorID   uuid.UUID  `json:"actor_id"`
+	ChirpID   *uuid.UUID `json:"chirp_id"`
+}
+
+// handlerNotificationsGet lists the caller's notifications, newest first.
+func (cfg *apiConfig) handlerNotificationsGet(w http.ResponseWriter, r *http.Request) {
+	userID := authenticatedUserID(r)
+
+	query := r.URL.Query()
+	limit, err := parseLimit(query)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	offset, err := parseOffset(query)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	dbNotifications, err := cfg.DB.GetNotifications(r.Context(), database.GetNotificationsParams{
+		UserID: userID,
+		Limit:  limit,
+		Offset: offset,
+	})
+	if err != nil {
+		log.Printf("Error getting notifications: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve notifications")
+		return
+	}
+
+	notifications := []Notification{}
+	for _, dbNotification := range dbNotifications {
+		notification := Notification{
+			ID:        dbNotification.ID,
+			CreatedAt: dbNotification.CreatedAt,
+			Kind:      dbNotification.Kind,
+			ActorID:   dbNotification.ActorID,
+		}
+		if dbNotification.ChirpID.Valid {
+			chirpID := dbNotification.ChirpID.UUID
+			notification.ChirpID = &chirpID
+		}
+		notifications = append(notifications, notification)
+	}
+
+	respondWithJSON(w, http.StatusOK, notifications)
+}
@@ -0,0 +1,498 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dbfletcher/chirpy/internal/auth"
+	"github.com/dbfletcher/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// scopeDescriptions are shown on the consent page.
+var scopeDescriptions = map[string]string{
+	auth.ScopeChirpsWrite:  "Post, edit and like chirps as you",
+	auth.ScopeChirpsDelete: "Delete your chirps",
+}
+
+var consentTemplate = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Authorize {{.ClientName}} - Chirpy</title>
+</head>
+<body>
+<h1>Authorize {{.ClientName}}</h1>
+<p>{{.ClientName}} would like to:</p>
+<ul>
+{{range .Scopes}}<li>{{.}}</li>
+{{end}}</ul>
+{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+<form method="post" action="/oauth/authorize">
+{{range $name, $value := .Params}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+{{end}}{{if .MFAToken}}<input type="hidden" name="mfa_token" value="{{.MFAToken}}">
+<p><label>Authentication code <input name="code" autocomplete="one-time-code" autofocus></label></p>
+<p><label>Or a recovery code <input name="recovery_code"></label></p>
+{{else}}<p><label>Email <input type="email" name="email" value="{{.Email}}" autocomplete="username" required></label></p>
+<p><label>Password <input type="password" name="password" autocomplete="current-password" required></label></p>
+{{end}}<p>
+<button type="submit" name="decision" value="approve">Approve</button>
+<button type="submit" name="decision" value="deny" formnovalidate>Deny</button>
+</p>
+</form>
+</body>
+</html>
+`))
+
+var oauthErrorTemplate = template.Must(template.New("oauthError").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Authorization failed - Chirpy</title>
+</head>
+<body>
+<h1>Authorization failed</h1>
+<p>{{.}}</p>
+</body>
+</html>
+`))
+
+type consentPage struct {
+	ClientName string
+	Scopes     []string
+	Params     map[string]string
+	Email      string
+	MFAToken   string
+	Error      string
+}
+
+// renderConsent shows the consent page for an authorization request. The
+// request's parameters are carried through the form so that approving it
+// can check them again.
+func renderConsent(w http.ResponseWriter, code int, req authorizationRequest, page consentPage) {
+	page.ClientName = req.Client.Name
+	for _, scope := range req.Scopes {
+		page.Scopes = append(page.Scopes, scopeDescriptions[scope])
+	}
+	page.Params = map[string]string{
+		"response_type":         "code",
+		"client_id":             req.Client.ID.String(),
+		"redirect_uri":          "",
+		"scope":                 strings.Join(req.Scopes, " "),
+		"state":                 req.State,
+		"code_challenge":        req.CodeChallenge,
+		"code_challenge_method": "S256",
+	}
+	if req.RedirectURISupplied {
+		page.Params["redirect_uri"] = req.RedirectURI
+	}
+
+	setConsentHeaders(w)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	err := consentTemplate.Execute(w, page)
+	if err != nil {
+		log.Printf("Error rendering consent page: %s", err)
+	}
+}
+
+func renderOAuthError(w http.ResponseWriter, code int, msg string) {
+	setConsentHeaders(w)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	err := oauthErrorTemplate.Execute(w, msg)
+	if err != nil {
+		log.Printf("Error rendering OAuth error page: %s", err)
+	}
+}
+
+// setConsentHeaders stops other sites framing the consent page to trick
+// users into approving an app.
+func setConsentHeaders(w http.ResponseWriter) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; frame-ancestors 'none'")
+}
+
+// handlerOAuthAuthorize starts the authorization code flow by asking the
+// user to log in and approve the app.
+func (cfg *apiConfig) handlerOAuthAuthorize(w http.ResponseWriter, r *http.Request) {
+	req, err := cfg.parseAuthorizationRequest(r)
+	if !cfg.checkAuthorizationRequest(w, r, req, err) {
+		return
+	}
+	renderConsent(w, http.StatusOK, req, consentPage{})
+}
+
+// checkAuthorizationRequest reports errors from parseAuthorizationRequest,
+// returning false if there were any.
+func (cfg *apiConfig) checkAuthorizationRequest(w http.ResponseWriter, r *http.Request, req authorizationRequest, err error) bool {
+	if err == nil {
+		return true
+	}
+	var redirectErr *oauthRedirectError
+	if errors.As(err, &redirectErr) {
+		redirectWithOAuthError(w, r, req, redirectErr.Code, redirectErr.Description)
+		return false
+	}
+	if errors.Is(err, errUnknownAuthorizationClient) || errors.Is(err, errUnregisteredRedirectURI) {
+		renderOAuthError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+	log.Printf("Error reading authorization request: %s", err)
+	renderOAuthError(w, http.StatusInternalServerError, "Something went wrong. Please try again later.")
+	return false
+}
+
+// handlerOAuthApprove handles the consent form. The user logs in with their
+// password, and their second factor if they have one, then is sent back to
+// the app with an authorization code.
+func (cfg *apiConfig) handlerOAuthApprove(w http.ResponseWriter, r *http.Request) {
+	req, err := cfg.parseAuthorizationRequest(r)
+	if !cfg.checkAuthorizationRequest(w, r, req, err) {
+		return
+	}
+
+	if r.PostFormValue("decision") != "approve" {
+		redirectWithOAuthError(w, r, req, oauthErrAccessDenied, "the user denied the request")
+		return
+	}
+
+	var userID uuid.UUID
+	if mfaToken := r.PostFormValue("mfa_token"); mfaToken != "" {
+		userID, err = cfg.completeMFAChallenge(r.Context(), mfaToken, r.PostFormValue("code"), r.PostFormValue("recovery_code"))
+		if err != nil {
+			switch {
+			case errors.Is(err, errInvalidMFACode):
+				renderConsent(w, http.StatusUnauthorized, req, consentPage{MFAToken: mfaToken, Error: "Invalid code"})
+			case errors.Is(err, errInvalidMFAChallenge):
+				renderConsent(w, http.StatusUnauthorized, req, consentPage{Error: "Your login has expired. Please log in again."})
+			default:
+				log.Printf("Error completing MFA challenge: %s", err)
+				renderOAuthError(w, http.StatusInternalServerError, "Couldn't log in. Please try again later.")
+			}
+			return
+		}
+	} else {
+		email := r.PostFormValue("email")
+		user, err := cfg.checkCredentials(r.Context(), email, r.PostFormValue("password"))
+		if err != nil {
+			switch {
+			case errors.Is(err, errIncorrectCredentials):
+				renderConsent(w, http.StatusUnauthorized, req, consentPage{Email: email, Error: "Incorrect email or password"})
+			case errors.Is(err, errEmailNotVerified):
+				renderConsent(w, http.StatusForbidden, req, consentPage{Email: email, Error: "Please verify your email address first"})
+			default:
+				log.Printf("Error checking credentials: %s", err)
+				renderOAuthError(w, http.StatusInternalServerError, "Couldn't log in. Please try again later.")
+			}
+			return
+		}
+		// Restoring an account is left to the user logging in themselves.
+		if user.DeletedAt.Valid {
+			renderConsent(w, http.StatusForbidden, req, consentPage{Email: email, Error: "This account is scheduled for deletion"})
+			return
+		}
+
+		required, err := cfg.mfaRequired(r.Context(), user.ID)
+		if err != nil {
+			log.Printf("Error checking MFA for user %s: %s", user.ID, err)
+			renderOAuthError(w, http.StatusInternalServerError, "Couldn't log in. Please try again later.")
+			return
+		}
+		if required {
+			mfaToken, _, err := cfg.createMFAChallenge(r.Context(), user.ID)
+			if err != nil {
+				log.Printf("Error creating MFA challenge: %s", err)
+				renderOAuthError(w, http.StatusInternalServerError, "Couldn't log in. Please try again later.")
+				return
+			}
+			renderConsent(w, http.StatusOK, req, consentPage{MFAToken: mfaToken})
+			return
+		}
+		userID = user.ID
+	}
+
+	user, err := cfg.DB.GetUser(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error getting user %s: %s", userID, err)
+		redirectWithOAuthError(w, r, req, oauthErrServerError, "couldn't authorize the app")
+		return
+	}
+
+	code, err := auth.MakeToken()
+	if err != nil {
+		redirectWithOAuthError(w, r, req, oauthErrServerError, "couldn't authorize the app")
+		return
+	}
+	err = cfg.DB.CreateOAuthAuthorizationCode(r.Context(), database.CreateOAuthAuthorizationCodeParams{
+		CodeHash:            auth.HashToken(code),
+		ClientID:            req.Client.ID,
+		UserID:              user.ID,
+		RedirectUri:         req.RedirectURI,
+		Scopes:              grantedScopes(user, req.Scopes),
+		CodeChallenge:       req.CodeChallenge,
+		ExpiresAt:           time.Now().UTC().Add(authorizationCodeTTL),
+		RedirectUriSupplied: req.RedirectURISupplied,
+	})
+	if err != nil {
+		log.Printf("Error saving authorization code for app %s: %s", req.Client.ID, err)
+		redirectWithOAuthError(w, r, req, oauthErrServerError, "couldn't authorize the app")
+		return
+	}
+
+	redirectToClient(w, r, req, url.Values{"code": {code}})
+}
+
+type oauthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+}
+
+func respondWithOAuthTokens(w http.ResponseWriter, accessToken, refreshToken string, scopes []string) {
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, http.StatusOK, oauthTokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(accessTokenTTL.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        strings.Join(scopes, " "),
+	})
+}
+
+// handlerOAuthToken lets apps exchange an authorization code, or a refresh
+// token they were given earlier, for tokens.
+func (cfg *apiConfig) handlerOAuthToken(w http.ResponseWriter, r *http.Request) {
+	client, err := cfg.authenticateClient(r)
+	if err != nil {
+		if errors.Is(err, errInvalidClient) {
+			respondWithOAuthError(w, http.StatusUnauthorized, oauthErrInvalidClient, "client authentication failed")
+			return
+		}
+		log.Printf("Error authenticating app: %s", err)
+		respondWithOAuthError(w, http.StatusInternalServerError, oauthErrServerError, "")
+		return
+	}
+
+	switch r.PostFormValue("grant_type") {
+	case "authorization_code":
+		cfg.exchangeAuthorizationCode(w, r, client)
+	case "refresh_token":
+		refreshed, err := cfg.refreshSession(r, r.PostFormValue("refresh_token"), uuid.NullUUID{UUID: client.ID, Valid: true})
+		if err != nil {
+			switch {
+			case errors.Is(err, errInvalidRefreshToken), errors.Is(err, errRefreshTokenRevoked), errors.Is(err, errRefreshTokenExpired):
+				respondWithOAuthError(w, http.StatusBadRequest, oauthErrInvalidGrant, err.Error())
+			default:
+				log.Printf("Error refreshing tokens for app %s: %s", client.ID, err)
+				respondWithOAuthError(w, http.StatusInternalServerError, oauthErrServerError, "")
+			}
+			return
+		}
+		respondWithOAuthTokens(w, refreshed.AccessToken, refreshed.RefreshToken, refreshed.Scopes)
+	case "":
+		respondWithOAuthError(w, http.StatusBadRequest, oauthErrInvalidRequest, "grant_type is required")
+	default:
+		respondWithOAuthError(w, http.StatusBadRequest, oauthErrUnsupportedGrantType, "")
+	}
+}
+
+// exchangeAuthorizationCode finishes the authorization code flow. Like a
+// refresh token, a code used twice means it has leaked, so the tokens it
+// was exchanged for are revoked.
+func (cfg *apiConfig) exchangeAuthorizationCode(w http.ResponseWriter, r *http.Request, client database.OauthClient) {
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithOAuthError(w, http.StatusInternalServerError, oauthErrServerError, "")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	codeHash := auth.HashToken(r.PostFormValue("code"))
+	code, err := qtx.GetOAuthAuthorizationCodeForUpdate(r.Context(), codeHash)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithOAuthError(w, http.StatusBadRequest, oauthErrInvalidGrant, "invalid authorization code")
+			return
+		}
+		log.Printf("Error getting authorization code: %s", err)
+		respondWithOAuthError(w, http.StatusInternalServerError, oauthErrServerError, "")
+		return
+	}
+
+	if code.UsedAt.Valid {
+		err = qtx.RevokeRefreshTokenFamily(r.Context(), code.FamilyID)
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			log.Printf("Error revoking refresh token family %s: %s", code.FamilyID, err)
+			respondWithOAuthError(w, http.StatusInternalServerError, oauthErrServerError, "")
+			return
+		}
+		log.Printf("SECURITY: authorization code reused for user %s and app %s, revoked token family %s (remote address %s)",
+			code.UserID, code.ClientID, code.FamilyID, r.RemoteAddr)
+		respondWithOAuthError(w, http.StatusBadRequest, oauthErrInvalidGrant, "invalid authorization code")
+		return
+	}
+	if time.Now().UTC().After(code.ExpiresAt) || code.ClientID != client.ID {
+		respondWithOAuthError(w, http.StatusBadRequest, oauthErrInvalidGrant, "invalid authorization code")
+		return
+	}
+	// redirect_uri is only required if the authorization request had one
+	// (RFC 6749, section 4.1.3), but a value that is sent must match.
+	redirectURI := r.PostFormValue("redirect_uri")
+	if (code.RedirectUriSupplied || redirectURI != "") && redirectURI != code.RedirectUri {
+		respondWithOAuthError(w, http.StatusBadRequest, oauthErrInvalidGrant, "redirect_uri doesn't match the authorization request")
+		return
+	}
+	if !auth.VerifyPKCE(r.PostFormValue("code_verifier"), code.CodeChallenge) {
+		respondWithOAuthError(w, http.StatusBadRequest, oauthErrInvalidGrant, "invalid code_verifier")
+		return
+	}
+
+	err = qtx.UseOAuthAuthorizationCode(r.Context(), codeHash)
+	if err != nil {
+		log.Printf("Error using authorization code: %s", err)
+		respondWithOAuthError(w, http.StatusInternalServerError, oauthErrServerError, "")
+		return
+	}
+
+	user, err := qtx.GetUser(r.Context(), code.UserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithOAuthError(w, http.StatusBadRequest, oauthErrInvalidGrant, "invalid authorization code")
+			return
+		}
+		log.Printf("Error getting user %s: %s", code.UserID, err)
+		respondWithOAuthError(w, http.StatusInternalServerError, oauthErrServerError, "")
+		return
+	}
+	scopes := grantedScopes(user, code.Scopes)
+
+	refreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithOAuthError(w, http.StatusInternalServerError, oauthErrServerError, "")
+		return
+	}
+	_, err = qtx.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
+		TokenHash: auth.HashToken(refreshToken),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().UTC().Add(refreshTokenTTL),
+		FamilyID:  code.FamilyID,
+		UserAgent: requestUserAgent(r),
+		IpAddress: requestIP(r),
+		ClientID:  uuid.NullUUID{UUID: client.ID, Valid: true},
+		Scopes:    code.Scopes,
+	})
+	if err != nil {
+		log.Printf("Error saving refresh token for app %s: %s", client.ID, err)
+		respondWithOAuthError(w, http.StatusInternalServerError, oauthErrServerError, "")
+		return
+	}
+
+	accessToken, err := cfg.makeAccessToken(user.ID, uuid.NullUUID{UUID: client.ID, Valid: true}, scopes)
+	if err != nil {
+		respondWithOAuthError(w, http.StatusInternalServerError, oauthErrServerError, "")
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("Error exchanging authorization code: %s", err)
+		respondWithOAuthError(w, http.StatusInternalServerError, oauthErrServerError, "")
+		return
+	}
+
+	respondWithOAuthTokens(w, accessToken, refreshToken, scopes)
+}
+
+// handlerOAuthRevoke lets an app end a session it was given, following
+// RFC 7009. Access tokens expire on their own, so only refresh tokens can be
+// revoked. Unknown tokens aren't an error, so an app can't probe for them.
+func (cfg *apiConfig) handlerOAuthRevoke(w http.ResponseWriter, r *http.Request) {
+	client, err := cfg.authenticateClient(r)
+	if err != nil {
+		if errors.Is(err, errInvalidClient) {
+			respondWithOAuthError(w, http.StatusUnauthorized, oauthErrInvalidClient, "client authentication failed")
+			return
+		}
+		log.Printf("Error authenticating app: %s", err)
+		respondWithOAuthError(w, http.StatusInternalServerError, oauthErrServerError, "")
+		return
+	}
+
+	token := r.PostFormValue("token")
+	if token == "" {
+		respondWithOAuthError(w, http.StatusBadRequest, oauthErrInvalidRequest, "token is required")
+		return
+	}
+
+	dbRefreshToken, err := cfg.DB.GetRefreshToken(r.Context(), auth.HashToken(token))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		log.Printf("Error getting refresh token: %s", err)
+		respondWithOAuthError(w, http.StatusInternalServerError, oauthErrServerError, "")
+		return
+	}
+	if dbRefreshToken.ClientID.UUID != client.ID || !dbRefreshToken.ClientID.Valid {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	err = cfg.DB.RevokeRefreshTokenFamily(r.Context(), dbRefreshToken.FamilyID)
+	if err != nil {
+		log.Printf("Error revoking refresh token family %s: %s", dbRefreshToken.FamilyID, err)
+		respondWithOAuthError(w, http.StatusInternalServerError, oauthErrServerError, "")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// handlerOAuthMetadata publishes the server's endpoints and capabilities at
+// /.well-known/oauth-authorization-server, as described in RFC 8414, so that
+// OAuth libraries can configure themselves. The issuer has to be the URL the
+// document was fetched under, without the well-known suffix, or clients
+// reject it; that is baseURL, not the iss claim of access tokens.
+func (cfg *apiConfig) handlerOAuthMetadata(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Issuer                            string   `json:"issuer"`
+		AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+		TokenEndpoint                     string   `json:"token_endpoint"`
+		RevocationEndpoint                string   `json:"revocation_endpoint"`
+		JWKSURI                           string   `json:"jwks_uri"`
+		ScopesSupported                   []string `json:"scopes_supported"`
+		ResponseTypesSupported            []string `json:"response_types_supported"`
+		GrantTypesSupported               []string `json:"grant_types_supported"`
+		TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+		CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, http.StatusOK, response{
+		Issuer:                            cfg.baseURL,
+		AuthorizationEndpoint:             cfg.baseURL + "/oauth/authorize",
+		TokenEndpoint:                     cfg.baseURL + "/oauth/token",
+		RevocationEndpoint:                cfg.baseURL + "/oauth/revoke",
+		JWKSURI:                           cfg.baseURL + "/.well-known/jwks.json",
+		ScopesSupported:                   delegatedScopes,
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code", "refresh_token"},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+	})
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dbfletcher/chirpy/internal/auth"
+	"github.com/dbfletcher/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+type OAuthClient struct {
+	ID           uuid.UUID `json:"client_id"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`
+	// Confidential apps authenticate with a secret. Public apps, which
+	// can't keep one, rely on PKCE alone.
+	Confidential bool      `json:"confidential"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func oauthClientFromDB(dbClient database.OauthClient) OAuthClient {
+	return OAuthClient{
+		ID:           dbClient.ID,
+		Name:         dbClient.Name,
+		RedirectURIs: dbClient.RedirectUris,
+		Scopes:       dbClient.Scopes,
+		Confidential: dbClient.SecretHash.Valid,
+		CreatedAt:    dbClient.CreatedAt,
+	}
+}
+
+// handlerOAuthClientsCreate registers a third-party app owned by the caller.
+// A confidential app's secret is only ever returned here.
+func (cfg *apiConfig) handlerOAuthClientsCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Scopes       []string `json:"scopes"`
+		Confidential bool     `json:"confidential"`
+	}
+	type response struct {
+		OAuthClient
+		ClientSecret string `json:"client_secret,omitempty"`
+	}
+
+	userID := authenticatedUserID(r)
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	name := strings.TrimSpace(params.Name)
+	if name == "" || len(name) > maxOAuthClientName {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("name must be between 1 and %d characters", maxOAuthClientName))
+		return
+	}
+	if len(params.RedirectURIs) == 0 || len(params.RedirectURIs) > maxRedirectURIs {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("redirect_uris must have between 1 and %d URIs", maxRedirectURIs))
+		return
+	}
+	for _, redirectURI := range params.RedirectURIs {
+		err = validateRedirectURI(redirectURI)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if len(params.Scopes) == 0 {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("scopes must include at least one of %s", strings.Join(delegatedScopes, ", ")))
+		return
+	}
+	for _, scope := range params.Scopes {
+		if !slices.Contains(delegatedScopes, scope) {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("apps can't have the %q scope", scope))
+			return
+		}
+	}
+
+	var secret string
+	secretHash := sql.NullString{}
+	if params.Confidential {
+		secret, err = auth.MakeToken()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't register app")
+			return
+		}
+		secretHash = sql.NullString{String: auth.HashToken(secret), Valid: true}
+	}
+
+	dbClient, err := cfg.DB.CreateOAuthClient(r.Context(), database.CreateOAuthClientParams{
+		OwnerID:      userID,
+		Name:         name,
+		SecretHash:   secretHash,
+		RedirectUris: params.RedirectURIs,
+		Scopes:       params.Scopes,
+	})
+	if err != nil {
+		log.Printf("Error registering app for user %s: %s", userID, err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't register app")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, response{
+		OAuthClient:  oauthClientFromDB(dbClient),
+		ClientSecret: secret,
+	})
+}
+
+// handlerOAuthClientsGet lists the apps the caller has registered.
+func (cfg *apiConfig) handlerOAuthClientsGet(w http.ResponseWriter, r *http.Request) {
+	userID := authenticatedUserID(r)
+
+	dbClients, err := cfg.DB.GetOAuthClientsForUser(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error getting apps of user %s: %s", userID, err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve apps")
+		return
+	}
+
+	clients := []OAuthClient{}
+	for _, dbClient := range dbClients {
+		clients = append(clients, oauthClientFromDB(dbClient))
+	}
+
+	respondWithJSON(w, http.StatusOK, clients)
+}
+
+// handlerOAuthClientsDelete removes one of the caller's apps. Every token
+// issued to it goes too, through ON DELETE CASCADE.
+func (cfg *apiConfig) handlerOAuthClientsDelete(w http.ResponseWriter, r *http.Request) {
+	userID := authenticatedUserID(r)
+
+	clientID, err := uuid.Parse(r.PathValue("clientID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid client ID")
+		return
+	}
+
+	deleted, err := cfg.DB.DeleteOAuthClient(r.Context(), database.DeleteOAuthClientParams{
+		ID:      clientID,
+		OwnerID: userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete app")
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, http.StatusNotFound, "App not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dbfletcher/chirpy/internal/auth"
+	"github.com/dbfletcher/chirpy/internal/database"
+	"github.com/dbfletcher/chirpy/internal/mail"
+)
+
+const passwordResetTTL = time.Hour
+
+// handlerPasswordForgot emails a password reset token. It always answers
+// 202 straight away, before looking the address up, so neither the status
+// nor the response time shows which addresses have accounts. Each address
+// gets a few tokens an hour at most.
+func (cfg *apiConfig) handlerPasswordForgot(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	cfg.startMailRequest("password reset", params.Email, cfg.forgotPassword)
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// forgotPassword runs in the background through startMailRequest.
+func (cfg *apiConfig) forgotPassword(ctx context.Context, email string) {
+	user, err := cfg.DB.GetUserByEmail(ctx, email)
+	if err == sql.ErrNoRows {
+		return
+	}
+	if err != nil {
+		log.Printf("Error looking up user for password reset: %s", err)
+		return
+	}
+	if user.DeletedAt.Valid {
+		return
+	}
+	err = cfg.sendPasswordReset(ctx, user)
+	if err != nil {
+		log.Printf("Error sending password reset to user %s: %s", user.ID, err)
+	}
+}
+
+// sendPasswordReset emails the user a single-use reset token. Only a hash of
+// the token is stored.
+func (cfg *apiConfig) sendPasswordReset(ctx context.Context, user database.User) error {
+	token, err := auth.MakeToken()
+	if err != nil {
+		return err
+	}
+
+	err = cfg.DB.CreatePasswordResetToken(ctx, database.CreatePasswordResetTokenParams{
+		TokenHash: auth.HashToken(token),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().UTC().Add(passwordResetTTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	return cfg.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Reset your Chirpy password",
+		Body: fmt.Sprintf("Someone asked to reset the password for your Chirpy account.\n\nTo choose a new password, send this token to %s/api/password/reset within %d minutes:\n\n%s\n\nIf it wasn't you, you can ignore this email and your password won't change.\n",
+			cfg.baseURL, int(passwordResetTTL.Minutes()), token),
+	})
+}
+
+// handlerPasswordReset sets a new password using an emailed reset token.
+// Using one token invalidates every other outstanding token for the user,
+// and all of their refresh tokens are revoked.
+func (cfg *apiConfig) handlerPasswordReset(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if len(params.NewPassword) < minPasswordLength {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Password must be at least %d characters", minPasswordLength))
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(params.NewPassword)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password")
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset password")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	// Lock the token so two requests can't both use it.
+	dbToken, err := qtx.GetPasswordResetTokenForUpdate(r.Context(), auth.HashToken(params.Token))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusBadRequest, "Invalid or expired reset token")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset password")
+		return
+	}
+	if dbToken.UsedAt.Valid || time.Now().UTC().After(dbToken.ExpiresAt) {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired reset token")
+		return
+	}
+
+	_, err = qtx.GetUser(r.Context(), dbToken.UserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusBadRequest, "Invalid or expired reset token")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset password")
+		return
+	}
+
+	err = qtx.UsePasswordResetTokensForUser(r.Context(), dbToken.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset password")
+		return
+	}
+
+	err = qtx.UpdateUserPassword(r.Context(), database.UpdateUserPasswordParams{
+		ID:             dbToken.UserID,
+		HashedPassword: hashedPassword,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset password")
+		return
+	}
+
+	err = qtx.RevokeAllRefreshTokensForUser(r.Context(), dbToken.UserID)
+	if err != nil {
+		log.Printf("Error revoking refresh tokens of user %s: %s", dbToken.UserID, err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset password")
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset password")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dbfletcher/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// Session is a login on one device. Its ID is the refresh token family, so
+// it stays the same as the refresh token is rotated. LastUsedAt, UserAgent
+// and IPAddress come from the latest refresh token, so they record the last
+// time the session was refreshed, not every request made with its access
+// tokens.
+type Session struct {
+	ID         uuid.UUID `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	SignedInAt time.Time `json:"signed_in_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	// ClientID is the app the session was authorized for, if it isn't one
+	// of the user's own logins.
+	ClientID *uuid.UUID `json:"client_id"`
+}
+
+// handlerSessionsGet lists the caller's active sessions, most recently used
+// first.
+func (cfg *apiConfig) handlerSessionsGet(w http.ResponseWriter, r *http.Request) {
+	userID := authenticatedUserID(r)
+
+	rows, err := cfg.DB.GetSessionsForUser(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error getting sessions: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve sessions")
+		return
+	}
+
+	sessions := []Session{}
+	for _, row := range rows {
+		session := Session{
+			ID:         row.FamilyID,
+			UserAgent:  row.UserAgent,
+			IPAddress:  row.IpAddress,
+			SignedInAt: row.SignedInAt,
+			LastUsedAt: row.LastUsedAt,
+			ExpiresAt:  row.ExpiresAt,
+		}
+		if row.ClientID.Valid {
+			session.ClientID = &row.ClientID.UUID
+		}
+		sessions = append(sessions, session)
+	}
+
+	respondWithJSON(w, http.StatusOK, sessions)
+}
+
+// handlerSessionsDelete logs one of the caller's sessions out. Its access
+// tokens keep working until they expire.
+func (cfg *apiConfig) handlerSessionsDelete(w http.ResponseWriter, r *http.Request) {
+	userID := authenticatedUserID(r)
+
+	sessionID, err := uuid.Parse(r.PathValue("sessionID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid session ID")
+		return
+	}
+
+	revoked, err := cfg.DB.RevokeSession(r.Context(), database.RevokeSessionParams{
+		FamilyID: sessionID,
+		UserID:   userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session")
+		return
+	}
+	if revoked == 0 {
+		respondWithError(w, http.StatusNotFound, "Session not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerSessionsRevokeAll logs the caller out everywhere, including the
+// session making the request.
+func (cfg *apiConfig) handlerSessionsRevokeAll(w http.ResponseWriter, r *http.Request) {
+	userID := authenticatedUserID(r)
+
+	err := cfg.DB.RevokeAllRefreshTokensForUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dbfletcher/chirpy/internal/database"
+)
+
+const (
+	defaultTrendingWindow = time.Hour
+	minTrendingWindow     = 5 * time.Minute
+	maxTrendingWindow     = 7 * 24 * time.Hour
+	defaultTrendingLimit  = 10
+)
+
+type TrendingTag struct {
+	Tag           string `json:"tag"`
+	Count         int64  `json:"count"`
+	PreviousCount int64  `json:"previous_count"`
+	// Velocity is the change in uses per hour between the previous window
+	// and the current one.
+	Velocity float64 `json:"velocity"`
+}
+
+// handlerTagsChirpsGet lists chirps carrying a hashtag, newest first.
+func (cfg *apiConfig) handlerTagsChirpsGet(w http.ResponseWriter, r *http.Request) {
+	viewerID, ok := cfg.optionalViewerID(w, r)
+	if !ok {
+		return
+	}
+
+	tag := normalizeTag(r.PathValue("tag"))
+	if tag == "" {
+		respondWithError(w, http.StatusBadRequest, "Invalid tag")
+		return
+	}
+
+	page, err := parsePageParams(r.URL.Query())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	cursorCreatedAt, cursorID := page.cursorArgs()
+	var dbChirps []database.Chirp
+	if page.backward() {
+		dbChirps, err = cfg.DB.GetChirpsByTagAfter(r.Context(), database.GetChirpsByTagAfterParams{
+			Tag:             tag,
+			CursorCreatedAt: cursorCreatedAt,
+			CursorID:        cursorID,
+			Limit:           page.fetchLimit(),
+		})
+	} else {
+		dbChirps, err = cfg.DB.GetChirpsByTagBefore(r.Context(), database.GetChirpsByTagBeforeParams{
+			Tag:             tag,
+			CursorCreatedAt: cursorCreatedAt,
+			CursorID:        cursorID,
+			Limit:           page.fetchLimit(),
+		})
+	}
+	if err != nil {
+		log.Printf("Error getting chirps tagged %q: %s", tag, err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chirps")
+		return
+	}
+
+	dbChirps, cursors := paginateChirps(dbChirps, page)
+
+	chirps, err := cfg.buildChirps(r.Context(), dbChirps, viewerID)
+	if err != nil {
+		log.Printf("Error building chirps: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chirps")
+		return
+	}
+
+	setPaginationLinks(w, r, cursors)
+	respondWithJSON(w, http.StatusOK, chirpPage{
+		Chirps:     chirps,
+		NextCursor: cursors.Next,
+		PrevCursor: cursors.Prev,
+	})
+}
+
+// handlerTagsTrending ranks tags by how much faster they are being used in
+// the latest window than in the window before it.
+func (cfg *apiConfig) handlerTagsTrending(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	window := defaultTrendingWindow
+	if windowStr := query.Get("window"); windowStr != "" {
+		var err error
+		window, err = time.ParseDuration(windowStr)
+		if err != nil || window < minTrendingWindow || window > maxTrendingWindow {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("window must be a duration between %s and %s", minTrendingWindow, maxTrendingWindow))
+			return
+		}
+	}
+
+	limit := int32(defaultTrendingLimit)
+	if query.Get("limit") != "" {
+		var err error
+		limit, err = parseLimit(query)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	windowStart := time.Now().UTC().Add(-window)
+	rows, err := cfg.DB.GetTrendingTags(r.Context(), database.GetTrendingTagsParams{
+		WindowStart:         windowStart,
+		PreviousWindowStart: windowStart.Add(-window),
+		Limit:               limit,
+	})
+	if err != nil {
+		log.Printf("Error getting trending tags: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve trending tags")
+		return
+	}
+
+	trending := []TrendingTag{}
+	for _, row := range rows {
+		trending = append(trending, TrendingTag{
+			Tag:           row.Name,
+			Count:         row.CurrentCount,
+			PreviousCount: row.PreviousCount,
+			Velocity:      float64(row.CurrentCount-row.PreviousCount) / window.Hours(),
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, trending)
+}
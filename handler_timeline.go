@@ -0,0 +1,59 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/dbfletcher/chirpy/internal/database"
+)
+
+// handlerTimeline lists chirps from the users the caller follows, newest
+// first, using the same cursors as GET /api/chirps.
+func (cfg *apiConfig) handlerTimeline(w http.ResponseWriter, r *http.Request) {
+	userID := authenticatedUserID(r)
+
+	page, err := parsePageParams(r.URL.Query())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	cursorCreatedAt, cursorID := page.cursorArgs()
+	var dbChirps []database.Chirp
+	if page.backward() {
+		dbChirps, err = cfg.DB.GetTimelineAfter(r.Context(), database.GetTimelineAfterParams{
+			UserID:          userID,
+			CursorCreatedAt: cursorCreatedAt,
+			CursorID:        cursorID,
+			Limit:           page.fetchLimit(),
+		})
+	} else {
+		dbChirps, err = cfg.DB.GetTimelineBefore(r.Context(), database.GetTimelineBeforeParams{
+			UserID:          userID,
+			CursorCreatedAt: cursorCreatedAt,
+			CursorID:        cursorID,
+			Limit:           page.fetchLimit(),
+		})
+	}
+	if err != nil {
+		log.Printf("Error getting timeline: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve timeline")
+		return
+	}
+
+	dbChirps, cursors := paginateChirps(dbChirps, page)
+
+	chirps, err := cfg.buildChirps(r.Context(), dbChirps, userID)
+	if err != nil {
+		log.Printf("Error building chirps: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve timeline")
+		return
+	}
+
+	setPaginationLinks(w, r, cursors)
+	respondWithJSON(w, http.StatusOK, chirpPage{
+		Chirps:     chirps,
+		NextCursor: cursors.Next,
+		PrevCursor: cursors.Prev,
+	})
+}
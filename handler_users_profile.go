@@ -0,0 +1,70 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Profile is the public view of a user. It deliberately leaves out the
+// email address.
+type Profile struct {
+	ID             uuid.UUID `json:"id"`
+	Handle         string    `json:"handle"`
+	DisplayName    string    `json:"display_name"`
+	Bio            string    `json:"bio"`
+	IsChirpyRed    bool      `json:"is_chirpy_red"`
+	CreatedAt      time.Time `json:"created_at"`
+	ChirpCount     int64     `json:"chirp_count"`
+	FollowerCount  int64     `json:"follower_count"`
+	FollowingCount int64     `json:"following_count"`
+}
+
+func (cfg *apiConfig) handlerUsersProfileGet(w http.ResponseWriter, r *http.Request) {
+	handle := strings.TrimPrefix(r.PathValue("handle"), "@")
+
+	dbUser, err := cfg.DB.GetUserByHandle(r.Context(), handle)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve user")
+		return
+	}
+
+	chirpCount, err := cfg.DB.CountChirpsByAuthor(r.Context(), dbUser.ID)
+	if err != nil {
+		log.Printf("Error counting chirps: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve user")
+		return
+	}
+	followerCount, err := cfg.DB.CountFollowers(r.Context(), dbUser.ID)
+	if err != nil {
+		log.Printf("Error counting followers: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve user")
+		return
+	}
+	followingCount, err := cfg.DB.CountFollowing(r.Context(), dbUser.ID)
+	if err != nil {
+		log.Printf("Error counting followed users: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve user")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Profile{
+		ID:             dbUser.ID,
+		Handle:         dbUser.Handle.String,
+		DisplayName:    dbUser.DisplayName,
+		Bio:            dbUser.Bio,
+		IsChirpyRed:    dbUser.IsChirpyRed,
+		CreatedAt:      dbUser.CreatedAt,
+		ChirpCount:     chirpCount,
+		FollowerCount:  followerCount,
+		FollowingCount: followingCount,
+	})
+}
@@ -2,14 +2,13 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
-	"fmt"
 	"net/http"
 	"strings"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -30,8 +29,38 @@ func GetAPIKey(headers http.Header) (string, error) {
 	return parts[1], nil
 }
 
+// apiKeyPrefix starts every personal API key, so leaked keys are easy to
+// recognise and scan for.
+const apiKeyPrefix = "chirpy_"
+
+// MakeAPIKey generates a personal API key: the "chirpy_" prefix followed by
+// a random 256-bit hex string.
+func MakeAPIKey() (string, error) {
+	token, err := MakeToken()
+	if err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + token, nil
+}
+
+// APIKeyPrefix returns the start of a personal API key, enough to tell keys
+// apart without revealing them.
+func APIKeyPrefix(key string) string {
+	const n = len(apiKeyPrefix) + 8
+	if len(key) < n {
+		return key
+	}
+	return key[:n]
+}
+
 // MakeRefreshToken generates a random 256-bit hex string.
 func MakeRefreshToken() (string, error) {
+	return MakeToken()
+}
+
+// MakeToken generates a random 256-bit hex string for opaque tokens such as
+// the ones in email verification links.
+func MakeToken() (string, error) {
 	b := make([]byte, 32)
 	_, err := rand.Read(b)
 	if err != nil {
@@ -40,6 +69,14 @@ func MakeRefreshToken() (string, error) {
 	return hex.EncodeToString(b), nil
 }
 
+// HashToken returns the hex-encoded SHA-256 of an opaque token, for storing
+// tokens so that a database leak doesn't reveal usable values. The tokens
+// are random, so unlike passwords they don't need a slow, salted hash.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 // GetBearerToken extracts a JWT from the Authorization header.
 func GetBearerToken(headers http.Header) (string, error) {
 	authHeader := headers.Get("Authorization")
@@ -70,43 +107,21 @@ func CheckPasswordHash(password, hash string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 }
 
-// MakeJWT creates a signed JWT for a specific user.
+// MakeJWT creates an HS256 access token for a specific user.
 func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
-	signingKey := []byte(tokenSecret)
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer:    "chirpy",
-		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
-		Subject:   userID.String(),
-	})
-
-	return token.SignedString(signingKey)
+	ks, err := NewKeySet("", NewHMACKey("", tokenSecret))
+	if err != nil {
+		return "", err
+	}
+	return ks.MakeJWT(userID, expiresIn)
 }
 
-// ValidateJWT validates a JWT and extracts the user ID (subject).
+// ValidateJWT validates an HS256 access token and extracts the user ID
+// (subject).
 func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	claims := &jwt.RegisteredClaims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(tokenSecret), nil
-	})
-
+	ks, err := NewKeySet("", NewHMACKey("", tokenSecret))
 	if err != nil {
 		return uuid.Nil, err
 	}
-
-	if !token.Valid {
-		return uuid.Nil, errors.New("invalid token")
-	}
-
-	userID, err := uuid.Parse(claims.Subject)
-	if err != nil {
-		return uuid.Nil, fmt.Errorf("could not parse subject from token: %w", err)
-	}
-
-	return userID, nil
+	return ks.ValidateJWT(tokenString)
 }
-
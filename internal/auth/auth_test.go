@@ -1,10 +1,19 @@
 package auth_test
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/dbfletcher/chirpy/internal/auth"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
@@ -55,3 +64,609 @@ func TestJWTFunctions(t *testing.T) {
 		}
 	})
 }
+
+func TestAPIKeys(t *testing.T) {
+	key, err := auth.MakeAPIKey()
+	if err != nil {
+		t.Fatalf("Failed to create API key: %v", err)
+	}
+	if !strings.HasPrefix(key, "chirpy_") || len(key) != len("chirpy_")+64 {
+		t.Errorf("Expected a chirpy_ key with 64 hex characters, but got %q", key)
+	}
+
+	prefix := auth.APIKeyPrefix(key)
+	if !strings.HasPrefix(key, prefix) || len(prefix) != len("chirpy_")+8 {
+		t.Errorf("Expected a short prefix of %q, but got %q", key, prefix)
+	}
+
+	headers := http.Header{}
+	headers.Set("Authorization", "ApiKey "+key)
+	got, err := auth.GetAPIKey(headers)
+	if err != nil {
+		t.Fatalf("Failed to read API key: %v", err)
+	}
+	if got != key {
+		t.Errorf("Expected key %q, but got %q", key, got)
+	}
+}
+
+func TestHashToken(t *testing.T) {
+	token, err := auth.MakeToken()
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+
+	hash := auth.HashToken(token)
+	if hash == token {
+		t.Error("Expected the hash to differ from the token")
+	}
+	if len(hash) != 64 {
+		t.Errorf("Expected a 64 character hex hash, but got %d characters", len(hash))
+	}
+	if auth.HashToken(token) != hash {
+		t.Error("Expected hashing the same token twice to give the same hash")
+	}
+
+	other, err := auth.MakeToken()
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+	if auth.HashToken(other) == hash {
+		t.Error("Expected different tokens to have different hashes")
+	}
+}
+
+func TestTOTP(t *testing.T) {
+	// The SHA-1 test vectors from RFC 6238, truncated to six digits. The
+	// secret is the ASCII string "12345678901234567890".
+	const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, v := range vectors {
+		code, err := auth.TOTPCode(rfcSecret, time.Unix(v.unix, 0))
+		if err != nil {
+			t.Fatalf("Failed to generate TOTP code: %v", err)
+		}
+		if code != v.code {
+			t.Errorf("At %d expected code %s, but got %s", v.unix, v.code, code)
+		}
+	}
+
+	t.Run("AcceptsAdjacentPeriods", func(t *testing.T) {
+		now := time.Unix(1234567890, 0)
+		code, err := auth.TOTPCode(rfcSecret, now.Add(-30*time.Second))
+		if err != nil {
+			t.Fatalf("Failed to generate TOTP code: %v", err)
+		}
+		if _, err := auth.ValidateTOTP(rfcSecret, code, now, 0); err != nil {
+			t.Errorf("Expected the previous period's code to be accepted, but got %v", err)
+		}
+	})
+
+	t.Run("RejectsDistantPeriods", func(t *testing.T) {
+		now := time.Unix(1234567890, 0)
+		code, err := auth.TOTPCode(rfcSecret, now.Add(-2*time.Minute))
+		if err != nil {
+			t.Fatalf("Failed to generate TOTP code: %v", err)
+		}
+		if _, err := auth.ValidateTOTP(rfcSecret, code, now, 0); err == nil {
+			t.Error("Expected a code from two minutes ago to be rejected, but it was accepted")
+		}
+	})
+
+	t.Run("RejectsReplay", func(t *testing.T) {
+		now := time.Unix(1234567890, 0)
+		step, err := auth.ValidateTOTP(rfcSecret, "005924", now, 0)
+		if err != nil {
+			t.Fatalf("Expected a valid code to be accepted, but got %v", err)
+		}
+		if _, err := auth.ValidateTOTP(rfcSecret, "005924", now, step); err == nil {
+			t.Error("Expected a used code to be rejected, but it was accepted")
+		}
+	})
+
+	t.Run("GeneratedSecret", func(t *testing.T) {
+		secret, err := auth.GenerateTOTPSecret()
+		if err != nil {
+			t.Fatalf("Failed to generate TOTP secret: %v", err)
+		}
+		now := time.Now()
+		code, err := auth.TOTPCode(secret, now)
+		if err != nil {
+			t.Fatalf("Failed to generate TOTP code: %v", err)
+		}
+		if _, err := auth.ValidateTOTP(secret, code, now, 0); err != nil {
+			t.Errorf("Expected the current code to be accepted, but got %v", err)
+		}
+	})
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := auth.GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatalf("Failed to generate recovery codes: %v", err)
+	}
+	if len(codes) != 10 {
+		t.Fatalf("Expected 10 codes, but got %d", len(codes))
+	}
+
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(strings.ReplaceAll(code, "-", "")) != 32 {
+			t.Errorf("Expected 32 characters of code, but got %q", code)
+		}
+		if seen[code] {
+			t.Errorf("Expected distinct codes, but got %s twice", code)
+		}
+		seen[code] = true
+	}
+
+	code := codes[0]
+	typed := strings.ToUpper(strings.ReplaceAll(code, "-", " "))
+	if auth.HashRecoveryCode(typed) != auth.HashRecoveryCode(code) {
+		t.Errorf("Expected %q and %q to hash the same", typed, code)
+	}
+}
+
+func TestKeySet(t *testing.T) {
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	rsaKey, err := auth.NewRSAKey("rsa-1", rsaPrivate)
+	if err != nil {
+		t.Fatalf("Failed to create RSA key: %v", err)
+	}
+	_, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate Ed25519 key: %v", err)
+	}
+	edKey := auth.NewEd25519Key("ed-1", edPrivate)
+	legacyKey := auth.NewHMACKey("", "my-super-secret-key-for-testing")
+
+	for _, signingID := range []string{"rsa-1", "ed-1"} {
+		t.Run("SignWith_"+signingID, func(t *testing.T) {
+			ks, err := auth.NewKeySet(signingID, rsaKey, edKey)
+			if err != nil {
+				t.Fatalf("Failed to create key set: %v", err)
+			}
+
+			userID := uuid.New()
+			tokenString, err := ks.MakeJWT(userID, time.Hour)
+			if err != nil {
+				t.Fatalf("Failed to create JWT: %v", err)
+			}
+			validatedUserID, err := ks.ValidateJWT(tokenString)
+			if err != nil {
+				t.Fatalf("Failed to validate a valid JWT: %v", err)
+			}
+			if validatedUserID != userID {
+				t.Errorf("Expected user ID %v, but got %v", userID, validatedUserID)
+			}
+		})
+	}
+
+	t.Run("Rotation", func(t *testing.T) {
+		oldSet, err := auth.NewKeySet("rsa-1", rsaKey)
+		if err != nil {
+			t.Fatalf("Failed to create key set: %v", err)
+		}
+		tokenString, err := oldSet.MakeJWT(uuid.New(), time.Hour)
+		if err != nil {
+			t.Fatalf("Failed to create JWT: %v", err)
+		}
+
+		// The old key stays in the set for verification only.
+		rsaPublic, err := x509.MarshalPKIXPublicKey(&rsaPrivate.PublicKey)
+		if err != nil {
+			t.Fatalf("Failed to marshal public key: %v", err)
+		}
+		retiredKey, err := auth.ParseKeyPEM("rsa-1", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: rsaPublic}))
+		if err != nil {
+			t.Fatalf("Failed to parse public key: %v", err)
+		}
+		newSet, err := auth.NewKeySet("ed-1", edKey, retiredKey)
+		if err != nil {
+			t.Fatalf("Failed to create key set: %v", err)
+		}
+		if _, err := newSet.ValidateJWT(tokenString); err != nil {
+			t.Errorf("Expected a token signed by the retired key to validate, but got %v", err)
+		}
+
+		if _, err := auth.NewKeySet("rsa-1", retiredKey); err == nil {
+			t.Error("Expected an error signing with a public key, but got none")
+		}
+	})
+
+	t.Run("UnknownKey", func(t *testing.T) {
+		signer, err := auth.NewKeySet("ed-1", edKey)
+		if err != nil {
+			t.Fatalf("Failed to create key set: %v", err)
+		}
+		tokenString, err := signer.MakeJWT(uuid.New(), time.Hour)
+		if err != nil {
+			t.Fatalf("Failed to create JWT: %v", err)
+		}
+
+		verifier, err := auth.NewKeySet("rsa-1", rsaKey)
+		if err != nil {
+			t.Fatalf("Failed to create key set: %v", err)
+		}
+		if _, err := verifier.ValidateJWT(tokenString); err == nil {
+			t.Error("Expected an error for a token signed by an unknown key, but got none")
+		}
+	})
+
+	t.Run("LegacyHMACToken", func(t *testing.T) {
+		userID := uuid.New()
+		tokenString, err := auth.MakeJWT(userID, "my-super-secret-key-for-testing", time.Hour)
+		if err != nil {
+			t.Fatalf("Failed to create JWT: %v", err)
+		}
+
+		ks, err := auth.NewKeySet("rsa-1", rsaKey, legacyKey)
+		if err != nil {
+			t.Fatalf("Failed to create key set: %v", err)
+		}
+		validatedUserID, err := ks.ValidateJWT(tokenString)
+		if err != nil {
+			t.Fatalf("Failed to validate a legacy JWT: %v", err)
+		}
+		if validatedUserID != userID {
+			t.Errorf("Expected user ID %v, but got %v", userID, validatedUserID)
+		}
+	})
+
+	t.Run("JWKS", func(t *testing.T) {
+		ks, err := auth.NewKeySet("rsa-1", rsaKey, edKey, legacyKey)
+		if err != nil {
+			t.Fatalf("Failed to create key set: %v", err)
+		}
+
+		jwks := ks.JWKS()
+		if len(jwks.Keys) != 2 {
+			t.Fatalf("Expected 2 published keys, but got %d", len(jwks.Keys))
+		}
+		for _, jwk := range jwks.Keys {
+			switch jwk.Kid {
+			case "rsa-1":
+				if jwk.Kty != "RSA" || jwk.Alg != "RS256" || jwk.N == "" || jwk.E != "AQAB" {
+					t.Errorf("Unexpected RSA JWK: %+v", jwk)
+				}
+			case "ed-1":
+				if jwk.Kty != "OKP" || jwk.Alg != "EdDSA" || jwk.Crv != "Ed25519" || jwk.X == "" {
+					t.Errorf("Unexpected Ed25519 JWK: %+v", jwk)
+				}
+			default:
+				t.Errorf("Unexpected key %q in JWKS", jwk.Kid)
+			}
+		}
+	})
+}
+
+func TestValidateJWTRejections(t *testing.T) {
+	const testSecret = "my-super-secret-key-for-testing"
+	userID := uuid.New()
+	now := time.Now()
+
+	// claims returns the claims of a valid access token for the key set
+	// below, for each case to alter.
+	claims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss": "chirpy",
+			"aud": "chirpy-api",
+			"sub": userID.String(),
+			"iat": now.Unix(),
+			"exp": now.Add(time.Hour).Unix(),
+			"typ": auth.TokenTypeAccess,
+		}
+	}
+	sign := func(t *testing.T, claims jwt.MapClaims) string {
+		t.Helper()
+		tokenString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testSecret))
+		if err != nil {
+			t.Fatalf("Failed to sign JWT: %v", err)
+		}
+		return tokenString
+	}
+
+	ks, err := auth.NewKeySet("", auth.NewHMACKey("", testSecret))
+	if err != nil {
+		t.Fatalf("Failed to create key set: %v", err)
+	}
+	ks.Audience = "chirpy-api"
+	ks.Leeway = time.Minute
+
+	tests := []struct {
+		name    string
+		token   func(t *testing.T) string
+		wantErr bool
+	}{
+		{
+			name:  "Valid",
+			token: func(t *testing.T) string { return sign(t, claims()) },
+		},
+		{
+			name: "ExpiredWithinLeeway",
+			token: func(t *testing.T) string {
+				c := claims()
+				c["exp"] = now.Add(-30 * time.Second).Unix()
+				return sign(t, c)
+			},
+		},
+		{
+			name: "ExpiredBeyondLeeway",
+			token: func(t *testing.T) string {
+				c := claims()
+				c["exp"] = now.Add(-2 * time.Minute).Unix()
+				return sign(t, c)
+			},
+			wantErr: true,
+		},
+		{
+			name: "MissingExpiry",
+			token: func(t *testing.T) string {
+				c := claims()
+				delete(c, "exp")
+				return sign(t, c)
+			},
+			wantErr: true,
+		},
+		{
+			name: "NotYetValid",
+			token: func(t *testing.T) string {
+				c := claims()
+				c["nbf"] = now.Add(5 * time.Minute).Unix()
+				return sign(t, c)
+			},
+			wantErr: true,
+		},
+		{
+			name: "IssuedInTheFuture",
+			token: func(t *testing.T) string {
+				c := claims()
+				c["iat"] = now.Add(5 * time.Minute).Unix()
+				return sign(t, c)
+			},
+			wantErr: true,
+		},
+		{
+			name: "WrongIssuer",
+			token: func(t *testing.T) string {
+				c := claims()
+				c["iss"] = "someone-else"
+				return sign(t, c)
+			},
+			wantErr: true,
+		},
+		{
+			name: "MissingIssuer",
+			token: func(t *testing.T) string {
+				c := claims()
+				delete(c, "iss")
+				return sign(t, c)
+			},
+			wantErr: true,
+		},
+		{
+			name: "WrongAudience",
+			token: func(t *testing.T) string {
+				c := claims()
+				c["aud"] = "another-api"
+				return sign(t, c)
+			},
+			wantErr: true,
+		},
+		{
+			name: "MissingAudience",
+			token: func(t *testing.T) string {
+				c := claims()
+				delete(c, "aud")
+				return sign(t, c)
+			},
+			wantErr: true,
+		},
+		{
+			name: "MFATokenAsAccessToken",
+			token: func(t *testing.T) string {
+				c := claims()
+				c["typ"] = "mfa"
+				return sign(t, c)
+			},
+			wantErr: true,
+		},
+		{
+			name: "MissingType",
+			token: func(t *testing.T) string {
+				c := claims()
+				delete(c, "typ")
+				return sign(t, c)
+			},
+			wantErr: true,
+		},
+		{
+			name: "InvalidSubject",
+			token: func(t *testing.T) string {
+				c := claims()
+				c["sub"] = "not-a-uuid"
+				return sign(t, c)
+			},
+			wantErr: true,
+		},
+		{
+			name: "UnsignedToken",
+			token: func(t *testing.T) string {
+				tokenString, err := jwt.NewWithClaims(jwt.SigningMethodNone, claims()).SignedString(jwt.UnsafeAllowNoneSignatureType)
+				if err != nil {
+					t.Fatalf("Failed to create unsigned JWT: %v", err)
+				}
+				return tokenString
+			},
+			wantErr: true,
+		},
+		{
+			name: "WrongSecret",
+			token: func(t *testing.T) string {
+				tokenString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims()).SignedString([]byte("a-different-secret"))
+				if err != nil {
+					t.Fatalf("Failed to sign JWT: %v", err)
+				}
+				return tokenString
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validatedUserID, err := ks.ValidateJWT(tt.token(t))
+			if tt.wantErr {
+				if err == nil {
+					t.Error("Expected an error, but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected a valid token, but got %v", err)
+			}
+			if validatedUserID != userID {
+				t.Errorf("Expected user ID %v, but got %v", userID, validatedUserID)
+			}
+		})
+	}
+
+	t.Run("WrongTypeError", func(t *testing.T) {
+		c := claims()
+		c["typ"] = "mfa"
+		if _, err := ks.ValidateJWT(sign(t, c)); !errors.Is(err, auth.ErrWrongTokenType) {
+			t.Errorf("Expected ErrWrongTokenType, but got %v", err)
+		}
+	})
+
+	// Tokens from before key sets have neither typ nor aud.
+	legacyClaims := func() jwt.MapClaims {
+		c := claims()
+		delete(c, "typ")
+		delete(c, "aud")
+		return c
+	}
+
+	t.Run("LegacyTokenDuringTransition", func(t *testing.T) {
+		legacyKS, err := auth.NewKeySet("", auth.NewHMACKey("", testSecret))
+		if err != nil {
+			t.Fatalf("Failed to create key set: %v", err)
+		}
+		legacyKS.Audience = "chirpy-api"
+		legacyKS.LegacyUntil = now.Add(time.Hour)
+
+		validatedUserID, err := legacyKS.ValidateJWT(sign(t, legacyClaims()))
+		if err != nil {
+			t.Fatalf("Expected a legacy token to be accepted, but got %v", err)
+		}
+		if validatedUserID != userID {
+			t.Errorf("Expected user ID %v, but got %v", userID, validatedUserID)
+		}
+
+		c := legacyClaims()
+		c["typ"] = "mfa"
+		if _, err := legacyKS.ValidateJWT(sign(t, c)); err == nil {
+			t.Error("Expected a token with another type to be rejected")
+		}
+	})
+
+	t.Run("LegacyTokenFromKeyWithID", func(t *testing.T) {
+		kidKS, err := auth.NewKeySet("hmac-1", auth.NewHMACKey("hmac-1", testSecret))
+		if err != nil {
+			t.Fatalf("Failed to create key set: %v", err)
+		}
+		kidKS.LegacyUntil = now.Add(time.Hour)
+
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, legacyClaims())
+		token.Header["kid"] = "hmac-1"
+		tokenString, err := token.SignedString([]byte(testSecret))
+		if err != nil {
+			t.Fatalf("Failed to sign JWT: %v", err)
+		}
+		if _, err := kidKS.ValidateJWT(tokenString); err == nil {
+			t.Error("Expected an untyped token from a key with an ID to be rejected")
+		}
+	})
+}
+
+func TestScopes(t *testing.T) {
+	ks, err := auth.NewKeySet("", auth.NewHMACKey("", "my-super-secret-key-for-testing"))
+	if err != nil {
+		t.Fatalf("Failed to create key set: %v", err)
+	}
+
+	userID := uuid.New()
+	tokenString, err := ks.MakeJWT(userID, time.Hour, auth.ScopeChirpsWrite, auth.ScopeUsersWrite)
+	if err != nil {
+		t.Fatalf("Failed to create JWT: %v", err)
+	}
+	claims, err := ks.ParseJWT(tokenString, auth.TokenTypeAccess)
+	if err != nil {
+		t.Fatalf("Failed to parse JWT: %v", err)
+	}
+
+	if got, _ := claims.UserID(); got != userID {
+		t.Errorf("Expected user ID %v, but got %v", userID, got)
+	}
+	if !claims.HasScope(auth.ScopeChirpsWrite) || !claims.HasScope(auth.ScopeUsersWrite) {
+		t.Errorf("Expected the granted scopes, but got %q", claims.Scope)
+	}
+	if claims.HasScope(auth.ScopeAdmin) {
+		t.Errorf("Expected no admin scope, but got %q", claims.Scope)
+	}
+}
+
+func TestPKCE(t *testing.T) {
+	// The example from RFC 7636, appendix B.
+	const verifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	const challenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	if got := auth.PKCEChallenge(verifier); got != challenge {
+		t.Errorf("Expected challenge %s, but got %s", challenge, got)
+	}
+	if !auth.VerifyPKCE(verifier, challenge) {
+		t.Error("Expected the RFC verifier to match its challenge")
+	}
+	if auth.VerifyPKCE(verifier+"x", challenge) {
+		t.Error("Expected a different verifier not to match")
+	}
+	if auth.VerifyPKCE("short", auth.PKCEChallenge("short")) {
+		t.Error("Expected a verifier shorter than 43 characters to be rejected")
+	}
+}
+
+func TestClientJWT(t *testing.T) {
+	ks, err := auth.NewKeySet("", auth.NewHMACKey("", "my-super-secret-key-for-testing"))
+	if err != nil {
+		t.Fatalf("Failed to create key set: %v", err)
+	}
+
+	clientID := uuid.New().String()
+	tokenString, err := ks.MakeClientJWT(uuid.New(), clientID, time.Hour, auth.ScopeChirpsWrite)
+	if err != nil {
+		t.Fatalf("Failed to create JWT: %v", err)
+	}
+	claims, err := ks.ParseJWT(tokenString, auth.TokenTypeAccess)
+	if err != nil {
+		t.Fatalf("Failed to parse JWT: %v", err)
+	}
+	if claims.ClientID != clientID {
+		t.Errorf("Expected client ID %s, but got %s", clientID, claims.ClientID)
+	}
+	if claims.Scope != auth.ScopeChirpsWrite {
+		t.Errorf("Expected scope %s, but got %q", auth.ScopeChirpsWrite, claims.Scope)
+	}
+}
@@ -0,0 +1,371 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// DefaultIssuer is the iss claim of tokens from a KeySet that hasn't been
+// given another issuer.
+const DefaultIssuer = "chirpy"
+
+// TokenTypeAccess is the typ claim of access tokens. Access tokens are the
+// only JWTs Chirpy issues; MFA challenge, password reset and verification
+// tokens are opaque random strings stored as hashes. The claim is there so
+// that any other kind of JWT added later, which must use a different type,
+// can't be presented as an access token.
+const TokenTypeAccess = "access"
+
+// ErrWrongTokenType is returned when a JWT is valid but of another type
+// than the one being checked for.
+var ErrWrongTokenType = errors.New("wrong token type")
+
+// Claims are the claims of the JWTs issued by a KeySet.
+type Claims struct {
+	jwt.RegisteredClaims
+	Type  string `json:"typ"`
+	Scope string `json:"scope,omitempty"`
+	// ClientID is set on tokens issued to a third-party app through OAuth.
+	ClientID string `json:"client_id,omitempty"`
+}
+
+// UserID returns the user the token was issued to.
+func (c *Claims) UserID() (uuid.UUID, error) {
+	userID, err := uuid.Parse(c.Subject)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("could not parse subject from token: %w", err)
+	}
+	return userID, nil
+}
+
+// minRSAKeyBits is the smallest RSA modulus accepted for signing or
+// verification.
+const minRSAKeyBits = 2048
+
+// Key is one entry in a KeySet. Asymmetric keys loaded from a public key
+// can only verify tokens; keys loaded from a private key can also sign.
+type Key struct {
+	ID        string
+	method    jwt.SigningMethod
+	signKey   any
+	verifyKey any
+}
+
+// NewHMACKey returns an HS256 key for the shared secret. A key with an empty
+// ID matches tokens that carry no kid header, which is how tokens were
+// issued before key sets existed.
+func NewHMACKey(id, secret string) *Key {
+	return &Key{
+		ID:        id,
+		method:    jwt.SigningMethodHS256,
+		signKey:   []byte(secret),
+		verifyKey: []byte(secret),
+	}
+}
+
+// NewRSAKey returns an RS256 signing key.
+func NewRSAKey(id string, private *rsa.PrivateKey) (*Key, error) {
+	if private.N.BitLen() < minRSAKeyBits {
+		return nil, fmt.Errorf("rsa key %q is shorter than %d bits", id, minRSAKeyBits)
+	}
+	return &Key{
+		ID:        id,
+		method:    jwt.SigningMethodRS256,
+		signKey:   private,
+		verifyKey: &private.PublicKey,
+	}, nil
+}
+
+// NewEd25519Key returns an EdDSA signing key.
+func NewEd25519Key(id string, private ed25519.PrivateKey) *Key {
+	return &Key{
+		ID:        id,
+		method:    jwt.SigningMethodEdDSA,
+		signKey:   private,
+		verifyKey: private.Public(),
+	}
+}
+
+// ParseKeyPEM parses an RSA or Ed25519 key in PEM form. Private keys may be
+// PKCS#1 (RSA only) or PKCS#8; public keys must be PKIX.
+func ParseKeyPEM(id string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("key %q is not PEM encoded", id)
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't parse key %q: %w", id, err)
+		}
+		return NewRSAKey(id, private)
+	case "PRIVATE KEY":
+		private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't parse key %q: %w", id, err)
+		}
+		switch private := private.(type) {
+		case *rsa.PrivateKey:
+			return NewRSAKey(id, private)
+		case ed25519.PrivateKey:
+			return NewEd25519Key(id, private), nil
+		}
+		return nil, fmt.Errorf("key %q has unsupported type %T", id, private)
+	case "PUBLIC KEY":
+		public, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't parse key %q: %w", id, err)
+		}
+		switch public := public.(type) {
+		case *rsa.PublicKey:
+			if public.N.BitLen() < minRSAKeyBits {
+				return nil, fmt.Errorf("rsa key %q is shorter than %d bits", id, minRSAKeyBits)
+			}
+			return &Key{ID: id, method: jwt.SigningMethodRS256, verifyKey: public}, nil
+		case ed25519.PublicKey:
+			return &Key{ID: id, method: jwt.SigningMethodEdDSA, verifyKey: public}, nil
+		}
+		return nil, fmt.Errorf("key %q has unsupported type %T", id, public)
+	}
+	return nil, fmt.Errorf("key %q has unsupported PEM type %q", id, block.Type)
+}
+
+// LoadKeyDir reads every *.pem file in dir as a key whose ID is the file
+// name without the extension.
+func LoadKeyDir(dir string) ([]*Key, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	keys := []*Key{}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		key, err := ParseKeyPEM(strings.TrimSuffix(filepath.Base(path), ".pem"), data)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// KeySet signs access tokens with one key and verifies them against every
+// key it holds, so a new signing key can be rolled out while tokens signed
+// by the previous one are still in circulation.
+type KeySet struct {
+	// Issuer is set as the iss claim of new tokens and required of the
+	// tokens being validated.
+	Issuer string
+	// Audience, if not empty, is set as the aud claim of new tokens and
+	// required of the tokens being validated.
+	Audience string
+	// Leeway is how far clocks may disagree when checking exp, nbf and iat.
+	Leeway time.Duration
+	// LegacyUntil is when access tokens from before key sets existed stop
+	// being accepted. Until then, a token signed by the kid-less HMAC key
+	// is taken as an access token even though it has no typ claim, and
+	// without an aud claim.
+	LegacyUntil time.Time
+
+	signing *Key
+	keys    map[string]*Key
+	methods []string
+}
+
+// NewKeySet builds a key set that signs with the key named signingID.
+func NewKeySet(signingID string, keys ...*Key) (*KeySet, error) {
+	ks := &KeySet{Issuer: DefaultIssuer, keys: map[string]*Key{}}
+	seenMethods := map[string]bool{}
+	for _, key := range keys {
+		if _, ok := ks.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate key id %q", key.ID)
+		}
+		ks.keys[key.ID] = key
+		if alg := key.method.Alg(); !seenMethods[alg] {
+			seenMethods[alg] = true
+			ks.methods = append(ks.methods, alg)
+		}
+	}
+
+	signing, ok := ks.keys[signingID]
+	if !ok {
+		return nil, fmt.Errorf("signing key %q not found", signingID)
+	}
+	if signing.signKey == nil {
+		return nil, fmt.Errorf("signing key %q is a public key", signingID)
+	}
+	ks.signing = signing
+	return ks, nil
+}
+
+// MakeJWT creates an access token for a specific user, granting scopes.
+func (ks *KeySet) MakeJWT(userID uuid.UUID, expiresIn time.Duration, scopes ...string) (string, error) {
+	return ks.makeJWT(userID, "", TokenTypeAccess, expiresIn, scopes)
+}
+
+// MakeClientJWT creates an access token for a specific user that a
+// third-party app acts with, granting scopes.
+func (ks *KeySet) MakeClientJWT(userID uuid.UUID, clientID string, expiresIn time.Duration, scopes ...string) (string, error) {
+	return ks.makeJWT(userID, clientID, TokenTypeAccess, expiresIn, scopes)
+}
+
+func (ks *KeySet) makeJWT(userID uuid.UUID, clientID, tokenType string, expiresIn time.Duration, scopes []string) (string, error) {
+	now := time.Now().UTC()
+	claims := Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    ks.Issuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+			Subject:   userID.String(),
+		},
+		Type:     tokenType,
+		Scope:    strings.Join(scopes, " "),
+		ClientID: clientID,
+	}
+	if ks.Audience != "" {
+		claims.Audience = jwt.ClaimStrings{ks.Audience}
+	}
+
+	token := jwt.NewWithClaims(ks.signing.method, claims)
+	if ks.signing.ID != "" {
+		token.Header["kid"] = ks.signing.ID
+	}
+
+	return token.SignedString(ks.signing.signKey)
+}
+
+// ValidateJWT validates an access token and extracts the user ID (subject).
+func (ks *KeySet) ValidateJWT(tokenString string) (uuid.UUID, error) {
+	claims, err := ks.ParseJWT(tokenString, TokenTypeAccess)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return claims.UserID()
+}
+
+// ParseJWT validates a JWT of the given type against the key named by its
+// kid header and returns its claims.
+func (ks *KeySet) ParseJWT(tokenString, tokenType string) (*Claims, error) {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(ks.methods),
+		jwt.WithIssuer(ks.Issuer),
+		jwt.WithLeeway(ks.Leeway),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	}
+
+	legacy := false
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := ks.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+		// The key decides the algorithm, never the token, so an RSA public
+		// key can't be replayed as an HMAC secret.
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		legacy = kid == "" && key.method == jwt.SigningMethodHS256
+		return key.verifyKey, nil
+	}, opts...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	untyped := legacy && claims.Type == "" && tokenType == TokenTypeAccess && time.Now().Before(ks.LegacyUntil)
+	if claims.Type != tokenType && !untyped {
+		return nil, fmt.Errorf("%w: got %q, want %q", ErrWrongTokenType, claims.Type, tokenType)
+	}
+
+	if ks.Audience != "" && !untyped && !slices.Contains(claims.Audience, ks.Audience) {
+		return nil, jwt.ErrTokenInvalidAudience
+	}
+
+	if _, err := claims.UserID(); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// JWK is a public key in JSON Web Key form (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public halves of the set's asymmetric keys. HMAC secrets
+// are never published.
+func (ks *KeySet) JWKS() JWKS {
+	ids := make([]string, 0, len(ks.keys))
+	for id := range ks.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	jwks := JWKS{Keys: []JWK{}}
+	for _, id := range ids {
+		key := ks.keys[id]
+		switch public := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			jwks.Keys = append(jwks.Keys, JWK{
+				Kty: "RSA",
+				Kid: id,
+				Use: "sig",
+				Alg: key.method.Alg(),
+				N:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			jwks.Keys = append(jwks.Keys, JWK{
+				Kty: "OKP",
+				Kid: id,
+				Use: "sig",
+				Alg: key.method.Alg(),
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+	return jwks
+}
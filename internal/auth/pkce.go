@@ -0,0 +1,26 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"regexp"
+)
+
+// pkceVerifierRegexp matches a PKCE code verifier as RFC 7636 defines it.
+var pkceVerifierRegexp = regexp.MustCompile(`^[A-Za-z0-9._~-]{43,128}$`)
+
+// PKCEChallenge returns the S256 code challenge for a code verifier.
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// VerifyPKCE reports whether verifier is well formed and matches an S256
+// code challenge.
+func VerifyPKCE(verifier, challenge string) bool {
+	if !pkceVerifierRegexp.MatchString(verifier) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(PKCEChallenge(verifier)), []byte(challenge)) == 1
+}
@@ -0,0 +1,27 @@
+package auth
+
+import "strings"
+
+// Scopes limit what an access token may be used for. They travel in the
+// space-separated scope claim, as in OAuth 2.0.
+const (
+	ScopeChirpsWrite  = "chirps:write"
+	ScopeChirpsDelete = "chirps:delete"
+	ScopeUsersWrite   = "users:write"
+	ScopeAdmin        = "admin"
+)
+
+// Scopes returns the scopes granted by the token.
+func (c *Claims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+// HasScope reports whether the token grants scope.
+func (c *Claims) HasScope(scope string) bool {
+	for _, s := range c.Scopes() {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,130 @@
+package mail_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dbfletcher/chirpy/internal/mail"
+)
+
+func TestMemorySender(t *testing.T) {
+	sender := &mail.MemorySender{}
+	msg := mail.Message{To: "user@example.com", Subject: "Hello", Body: "Hi there"}
+
+	err := sender.Send(context.Background(), msg)
+	if err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+
+	messages := sender.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("Expected 1 message, but got %d", len(messages))
+	}
+	if messages[0] != msg {
+		t.Errorf("Expected %+v, but got %+v", msg, messages[0])
+	}
+}
+
+func TestFileSender(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	sender := &mail.FileSender{Dir: dir, From: "chirpy@example.com"}
+
+	err := sender.Send(context.Background(), mail.Message{
+		To:      "user@example.com",
+		Subject: "Verify your email",
+		Body:    "Line one\nLine two",
+	})
+	if err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to read mail directory: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 file, but got %d", len(entries))
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, entries[0].Name()))
+	if err != nil {
+		t.Fatalf("Failed to read message: %v", err)
+	}
+	content := string(data)
+	for _, want := range []string{
+		"From: chirpy@example.com\r\n",
+		"To: user@example.com\r\n",
+		"Subject: Verify your email\r\n",
+		"\r\n\r\nLine one\r\nLine two",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("Expected message to contain %q, but got %q", want, content)
+		}
+	}
+}
+
+// blockingSender holds every message until its context is done.
+type blockingSender struct {
+	done chan error
+}
+
+func (s *blockingSender) Send(ctx context.Context, msg mail.Message) error {
+	<-ctx.Done()
+	s.done <- ctx.Err()
+	return ctx.Err()
+}
+
+func TestQueue(t *testing.T) {
+	t.Run("Delivers", func(t *testing.T) {
+		sender := &mail.MemorySender{}
+		queue := mail.NewQueue(sender, 1, 10, time.Second)
+		msg := mail.Message{To: "user@example.com", Subject: "Hello", Body: "Hi there"}
+
+		err := queue.Send(context.Background(), msg)
+		if err != nil {
+			t.Fatalf("Failed to queue message: %v", err)
+		}
+		deadline := time.Now().Add(time.Second)
+		for len(sender.Messages()) == 0 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if messages := sender.Messages(); len(messages) != 1 || messages[0] != msg {
+			t.Errorf("Expected %+v to be delivered, but got %+v", msg, messages)
+		}
+	})
+
+	t.Run("TimesOutAndFillsUp", func(t *testing.T) {
+		sender := &blockingSender{done: make(chan error, 2)}
+		queue := mail.NewQueue(sender, 1, 1, 50*time.Millisecond)
+		msg := mail.Message{To: "user@example.com"}
+
+		// The worker takes the first message and the second waits in the
+		// queue, which leaves no room for a third.
+		err := queue.Send(context.Background(), msg)
+		if err != nil {
+			t.Fatalf("Failed to queue message: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+		err = queue.Send(context.Background(), msg)
+		if err != nil {
+			t.Fatalf("Failed to queue message: %v", err)
+		}
+		err = queue.Send(context.Background(), msg)
+		if err != mail.ErrQueueFull {
+			t.Errorf("Expected ErrQueueFull, but got %v", err)
+		}
+
+		select {
+		case err := <-sender.done:
+			if err != context.DeadlineExceeded {
+				t.Errorf("Expected the send to time out, but got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected the send to time out")
+		}
+	})
+}
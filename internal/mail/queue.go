@@ -0,0 +1,56 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// ErrQueueFull is returned by Queue.Send when the queue has no room left.
+var ErrQueueFull = errors.New("mail queue is full")
+
+// Queue is a Sender that hands messages to a fixed number of workers, so
+// callers don't wait for delivery and a slow mail server can't tie up more
+// than that many goroutines. Each message gets Timeout to be delivered.
+type Queue struct {
+	sender  Sender
+	timeout time.Duration
+	jobs    chan Message
+}
+
+// NewQueue starts workers that deliver through sender. Up to size messages
+// can wait for a worker before Send starts refusing them.
+func NewQueue(sender Sender, workers, size int, timeout time.Duration) *Queue {
+	q := &Queue{
+		sender:  sender,
+		timeout: timeout,
+		jobs:    make(chan Message, size),
+	}
+	for i := 0; i < workers; i++ {
+		go q.work()
+	}
+	return q
+}
+
+// Send queues msg without waiting for it to be delivered. Delivery errors
+// are logged, since there's no one left to return them to.
+func (q *Queue) Send(ctx context.Context, msg Message) error {
+	select {
+	case q.jobs <- msg:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+func (q *Queue) work() {
+	for msg := range q.jobs {
+		ctx, cancel := context.WithTimeout(context.Background(), q.timeout)
+		err := q.sender.Send(ctx, msg)
+		cancel()
+		if err != nil {
+			log.Printf("Error sending %q: %s", msg.Subject, err)
+		}
+	}
+}
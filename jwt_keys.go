@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/dbfletcher/chirpy/internal/auth"
+)
+
+// jwtKeySetFromEnv builds the access token keys. JWT_KEYS_DIR holds RSA or
+// Ed25519 PEM files named <kid>.pem and JWT_SIGNING_KID picks the one that
+// signs new tokens; the others only verify, which is how a key is retired.
+// JWT_SECRET, if set, keeps verifying HS256 tokens without a kid, and signs
+// new tokens when no key directory is configured. Tokens it signed before
+// key sets existed carry no typ or aud claim; they are accepted for one
+// access token lifetime after startup, by which time they have all expired.
+// JWT_ISSUER, JWT_AUDIENCE and JWT_LEEWAY set the claims tokens are issued
+// with and checked against.
+func jwtKeySetFromEnv() (*auth.KeySet, error) {
+	ks, err := loadJWTKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	if issuer := os.Getenv("JWT_ISSUER"); issuer != "" {
+		ks.Issuer = issuer
+	}
+	ks.Audience = os.Getenv("JWT_AUDIENCE")
+	ks.LegacyUntil = time.Now().Add(accessTokenTTL)
+	if leewayStr := os.Getenv("JWT_LEEWAY"); leewayStr != "" {
+		leeway, err := time.ParseDuration(leewayStr)
+		if err != nil || leeway < 0 {
+			return nil, errors.New("JWT_LEEWAY must be a non-negative duration")
+		}
+		ks.Leeway = leeway
+	}
+	return ks, nil
+}
+
+func loadJWTKeys() (*auth.KeySet, error) {
+	keys := []*auth.Key{}
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		keys = append(keys, auth.NewHMACKey("", secret))
+	}
+
+	dir := os.Getenv("JWT_KEYS_DIR")
+	if dir == "" {
+		if len(keys) == 0 {
+			return nil, errors.New("JWT_SECRET or JWT_KEYS_DIR environment variable must be set")
+		}
+		return auth.NewKeySet("", keys...)
+	}
+
+	dirKeys, err := auth.LoadKeyDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	keys = append(keys, dirKeys...)
+
+	signingKID := os.Getenv("JWT_SIGNING_KID")
+	if signingKID == "" {
+		return nil, errors.New("JWT_SIGNING_KID environment variable must be set with JWT_KEYS_DIR")
+	}
+	return auth.NewKeySet(signingKID, keys...)
+}
+
+// handlerJWKS publishes the public keys access tokens can be verified with.
+func (cfg *apiConfig) handlerJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, http.StatusOK, cfg.jwtKeys.JWKS())
+}
@@ -3,16 +3,20 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
 
 	"github.com/dbfletcher/chirpy/internal/auth"
 	"github.com/dbfletcher/chirpy/internal/database"
+	"github.com/dbfletcher/chirpy/internal/mail"
 	"github.com/google/uuid"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
@@ -20,10 +24,24 @@ import (
 
 type apiConfig struct {
 	fileserverHits atomic.Int32
+	db             *sql.DB
 	DB             *database.Queries
 	Platform       string
-	jwtSecret      string
+	jwtKeys        *auth.KeySet
 	polkaKey       string
+	// accountDeletionGrace is how long a deleted account can still be
+	// restored by logging in before it is purged.
+	accountDeletionGrace time.Duration
+	// mailer is a mail.Queue, so sending doesn't wait for delivery.
+	mailer mail.Sender
+	// mailLimiter and mailRequests limit the email sent for requests
+	// that anyone can make; see startMailRequest.
+	mailLimiter  *addressLimiter
+	mailRequests chan struct{}
+	// baseURL is where the API is reachable from outside, for links in
+	// emails.
+	baseURL                  string
+	requireEmailVerification bool
 }
 
 type User struct {
@@ -32,14 +50,40 @@ type User struct {
 	UpdatedAt   time.Time `json:"updated_at"`
 	Email       string    `json:"email"`
 	IsChirpyRed bool      `json:"is_chirpy_red"`
+	Handle      *string   `json:"handle"`
+	DisplayName string    `json:"display_name"`
+	Bio         string    `json:"bio"`
+	// EmailVerified is false until the user follows the link emailed to
+	// their current address.
+	EmailVerified bool `json:"email_verified"`
+	IsAdmin       bool `json:"is_admin"`
 }
 
 type Chirp struct {
-	ID        uuid.UUID `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Body      string    `json:"body"`
-	UserID    uuid.UUID `json:"user_id"`
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	Body       string     `json:"body"`
+	Edited     bool       `json:"edited"`
+	UserID     uuid.UUID  `json:"user_id"`
+	InReplyTo  *uuid.UUID `json:"in_reply_to"`
+	ReplyCount int64      `json:"reply_count"`
+	LikeCount  int64      `json:"like_count"`
+	Liked      *bool      `json:"liked,omitempty"`
+	Mentions   []Mention  `json:"mentions"`
+	// Kind is "chirp", "rechirp" or "quote". Rechirps and quotes embed the
+	// chirp they point at.
+	Kind            string         `json:"kind"`
+	ReferencedChirp *EmbeddedChirp `json:"referenced_chirp,omitempty"`
+	// ParentDeleted is set on a reply to a chirp that has been deleted.
+	ParentDeleted bool `json:"parent_deleted"`
+}
+
+// EmbeddedChirp is the chirp a rechirp or quote points at. Once that chirp
+// has been deleted only a tombstone with Deleted set remains.
+type EmbeddedChirp struct {
+	*Chirp
+	Deleted bool `json:"deleted,omitempty"`
 }
 
 func main() {
@@ -53,14 +97,38 @@ func main() {
 	if dbURL == "" {
 		log.Fatal("DB_URL environment variable is not set")
 	}
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		log.Fatal("JWT_SECRET environment variable is not set")
+	jwtKeys, err := jwtKeySetFromEnv()
+	if err != nil {
+		log.Fatalf("Can't load JWT keys: %s", err)
 	}
 	polkaKey := os.Getenv("POLKA_KEY")
 	if polkaKey == "" {
 		log.Fatal("POLKA_KEY environment variable is not set")
 	}
+	accountDeletionGrace := defaultAccountDeletionGrace
+	if graceStr := os.Getenv("ACCOUNT_DELETION_GRACE"); graceStr != "" {
+		grace, err := time.ParseDuration(graceStr)
+		if err != nil || grace < 0 {
+			log.Fatal("ACCOUNT_DELETION_GRACE must be a non-negative duration")
+		}
+		accountDeletionGrace = grace
+	}
+	baseURL := strings.TrimSuffix(os.Getenv("BASE_URL"), "/")
+	if baseURL == "" {
+		baseURL = "http://localhost:8080"
+	}
+	requireEmailVerification := false
+	if requireStr := os.Getenv("REQUIRE_EMAIL_VERIFICATION"); requireStr != "" {
+		require, err := strconv.ParseBool(requireStr)
+		if err != nil {
+			log.Fatal("REQUIRE_EMAIL_VERIFICATION must be true or false")
+		}
+		requireEmailVerification = require
+	}
+	mailFrom := os.Getenv("MAIL_FROM")
+	if mailFrom == "" {
+		mailFrom = "no-reply@localhost"
+	}
 
 	db, err := sql.Open("postgres", dbURL)
 	if err != nil {
@@ -69,12 +137,21 @@ func main() {
 	defer db.Close()
 
 	apiCfg := &apiConfig{
-		DB:        database.New(db),
-		Platform:  platform,
-		jwtSecret: jwtSecret,
-		polkaKey:  polkaKey,
+		db:                       db,
+		DB:                       database.New(db),
+		Platform:                 platform,
+		jwtKeys:                  jwtKeys,
+		polkaKey:                 polkaKey,
+		accountDeletionGrace:     accountDeletionGrace,
+		mailer:                   mail.NewQueue(mailSenderFromEnv(platform, mailFrom), mailWorkers, mailQueueSize, mailSendTimeout),
+		mailLimiter:              newAddressLimiter(maxMailsPerAddress, mailLimitWindow),
+		mailRequests:             make(chan struct{}, maxMailRequests),
+		baseURL:                  baseURL,
+		requireEmailVerification: requireEmailVerification,
 	}
 
+	go apiCfg.runPurgeJobs(purgeInterval)
+
 	mux := http.NewServeMux()
 	const filepathRoot = "."
 	fileServer := http.FileServer(http.Dir(filepathRoot))
@@ -83,19 +160,66 @@ func main() {
 
 	// API endpoints
 	mux.HandleFunc("GET /api/healthz", handlerReadiness)
+	mux.HandleFunc("GET /.well-known/jwks.json", apiCfg.handlerJWKS)
+	mux.HandleFunc("GET /.well-known/oauth-authorization-server", apiCfg.handlerOAuthMetadata)
 	mux.HandleFunc("POST /api/users", apiCfg.handlerUsersCreate)
-	mux.HandleFunc("PUT /api/users", apiCfg.handlerUsersUpdate)
+	mux.HandleFunc("PUT /api/users", apiCfg.middlewareAuth(apiCfg.handlerUsersUpdate, auth.ScopeUsersWrite))
+	mux.HandleFunc("PATCH /api/users", apiCfg.middlewareAuth(apiCfg.handlerUsersUpdate, auth.ScopeUsersWrite))
+	mux.HandleFunc("DELETE /api/users", apiCfg.middlewareAuth(apiCfg.handlerUsersDelete, auth.ScopeUsersWrite))
+	mux.HandleFunc("POST /api/users/password", apiCfg.middlewareAuth(apiCfg.handlerUsersPasswordChange, auth.ScopeUsersWrite))
+	mux.HandleFunc("POST /api/users/totp/enroll", apiCfg.middlewareAuth(apiCfg.handlerTOTPEnroll, auth.ScopeUsersWrite))
+	mux.HandleFunc("POST /api/users/totp/confirm", apiCfg.middlewareAuth(apiCfg.handlerTOTPConfirm, auth.ScopeUsersWrite))
+	mux.HandleFunc("POST /api/users/totp/disable", apiCfg.middlewareAuth(apiCfg.handlerTOTPDisable, auth.ScopeUsersWrite))
+	mux.HandleFunc("POST /api/exports", apiCfg.middlewareAuth(apiCfg.handlerDataExportCreate))
+	mux.HandleFunc("GET /api/exports/{exportID}", apiCfg.middlewareAuth(apiCfg.handlerDataExportGet))
+	mux.HandleFunc("GET /api/exports/{exportID}/download", apiCfg.middlewareAuth(apiCfg.handlerDataExportDownload))
+	mux.HandleFunc("GET /api/users/{handle}", apiCfg.handlerUsersProfileGet)
+	mux.HandleFunc("POST /api/users/{userID}/follow", apiCfg.middlewareAuth(apiCfg.handlerFollowCreate, auth.ScopeUsersWrite))
+	mux.HandleFunc("DELETE /api/users/{userID}/follow", apiCfg.middlewareAuth(apiCfg.handlerFollowDelete, auth.ScopeUsersWrite))
+	mux.HandleFunc("GET /api/users/{userID}/followers", apiCfg.handlerFollowersGet)
+	mux.HandleFunc("GET /api/users/{userID}/following", apiCfg.handlerFollowingGet)
+	mux.HandleFunc("GET /api/verify", apiCfg.handlerVerifyEmail)
+	mux.HandleFunc("POST /api/verify/resend", apiCfg.handlerVerifyEmailResend)
+	mux.HandleFunc("POST /api/password/forgot", apiCfg.handlerPasswordForgot)
+	mux.HandleFunc("POST /api/password/reset", apiCfg.handlerPasswordReset)
 	mux.HandleFunc("POST /api/login", apiCfg.handlerLogin)
+	mux.HandleFunc("POST /api/login/mfa", apiCfg.handlerLoginMFA)
 	mux.HandleFunc("POST /api/refresh", apiCfg.handlerRefresh)
 	mux.HandleFunc("POST /api/revoke", apiCfg.handlerRevoke)
-	mux.HandleFunc("POST /api/chirps", apiCfg.handlerChirpsCreate)
+	mux.HandleFunc("POST /api/keys", apiCfg.middlewareAuth(apiCfg.handlerAPIKeysCreate, auth.ScopeUsersWrite))
+	mux.HandleFunc("GET /api/keys", apiCfg.middlewareAuth(apiCfg.handlerAPIKeysGet))
+	mux.HandleFunc("DELETE /api/keys/{keyID}", apiCfg.middlewareAuth(apiCfg.handlerAPIKeysDelete, auth.ScopeUsersWrite))
+	mux.HandleFunc("POST /api/oauth/clients", apiCfg.middlewareAuth(apiCfg.handlerOAuthClientsCreate, auth.ScopeUsersWrite))
+	mux.HandleFunc("GET /api/oauth/clients", apiCfg.middlewareAuth(apiCfg.handlerOAuthClientsGet))
+	mux.HandleFunc("DELETE /api/oauth/clients/{clientID}", apiCfg.middlewareAuth(apiCfg.handlerOAuthClientsDelete, auth.ScopeUsersWrite))
+	mux.HandleFunc("GET /oauth/authorize", apiCfg.handlerOAuthAuthorize)
+	mux.HandleFunc("POST /oauth/authorize", apiCfg.handlerOAuthApprove)
+	mux.HandleFunc("POST /oauth/token", apiCfg.handlerOAuthToken)
+	mux.HandleFunc("POST /oauth/revoke", apiCfg.handlerOAuthRevoke)
+	mux.HandleFunc("GET /api/sessions", apiCfg.middlewareAuth(apiCfg.handlerSessionsGet))
+	mux.HandleFunc("DELETE /api/sessions/{sessionID}", apiCfg.middlewareAuth(apiCfg.handlerSessionsDelete, auth.ScopeUsersWrite))
+	mux.HandleFunc("POST /api/sessions/revoke-all", apiCfg.middlewareAuth(apiCfg.handlerSessionsRevokeAll, auth.ScopeUsersWrite))
+	mux.HandleFunc("POST /api/chirps", apiCfg.middlewareAppAuth(apiCfg.handlerChirpsCreate, auth.ScopeChirpsWrite))
 	mux.HandleFunc("GET /api/chirps", apiCfg.handlerChirpsGet)
+	mux.HandleFunc("GET /api/chirps/search", apiCfg.handlerChirpsSearch)
 	mux.HandleFunc("GET /api/chirps/{chirpID}", apiCfg.handlerChirpsGetByID)
-	mux.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.handlerChirpsDelete)
+	mux.HandleFunc("GET /api/chirps/{chirpID}/thread", apiCfg.handlerChirpsThread)
+	mux.HandleFunc("PUT /api/chirps/{chirpID}/like", apiCfg.middlewareAppAuth(apiCfg.handlerChirpsLike, auth.ScopeChirpsWrite))
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}/like", apiCfg.middlewareAppAuth(apiCfg.handlerChirpsUnlike, auth.ScopeChirpsWrite))
+	mux.HandleFunc("GET /api/chirps/{chirpID}/likes", apiCfg.handlerChirpsLikesGet)
+	mux.HandleFunc("PUT /api/chirps/{chirpID}", apiCfg.middlewareAppAuth(apiCfg.handlerChirpsUpdate, auth.ScopeChirpsWrite))
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.middlewareAppAuth(apiCfg.handlerChirpsDelete, auth.ScopeChirpsDelete))
+	mux.HandleFunc("GET /api/chirps/{chirpID}/revisions", apiCfg.handlerChirpsRevisionsGet)
+	mux.HandleFunc("GET /api/timeline", apiCfg.middlewareAppAuth(apiCfg.handlerTimeline))
+	mux.HandleFunc("GET /api/notifications", apiCfg.middlewareAuth(apiCfg.handlerNotificationsGet))
+	mux.HandleFunc("GET /api/tags/trending", apiCfg.handlerTagsTrending)
+	mux.HandleFunc("GET /api/tags/{tag}/chirps", apiCfg.handlerTagsChirpsGet)
 	mux.HandleFunc("POST /api/polka/webhooks", apiCfg.handlerWebhookPolka)
 
-	// Admin endpoints
-	mux.HandleFunc("GET /admin/metrics", apiCfg.handlerMetrics)
+	// Admin endpoints. Metrics used to be public; they now need an admin's
+	// access token. Reset is only available on the dev platform and needs
+	// no login, since it deletes every user, admins included.
+	mux.HandleFunc("GET /admin/metrics", apiCfg.middlewareAuth(apiCfg.handlerMetrics, auth.ScopeAdmin))
 	mux.HandleFunc("POST /admin/reset", apiCfg.handlerReset)
 
 	server := &http.Server{
@@ -111,41 +235,140 @@ func main() {
 }
 
 func (cfg *apiConfig) handlerChirpsGet(w http.ResponseWriter, r *http.Request) {
-	authorIDStr := r.URL.Query().Get("author_id")
-	var dbChirps []database.Chirp
-	var err error // Declare err in the outer scope
+	viewerID, ok := cfg.optionalViewerID(w, r)
+	if !ok {
+		return
+	}
 
-	if authorIDStr != "" {
-		authorID, errParse := uuid.Parse(authorIDStr)
-		if errParse != nil {
-			respondWithError(w, http.StatusBadRequest, "Invalid author ID")
-			return
-		}
-		// Use = to assign to the existing err variable
-		dbChirps, err = cfg.DB.GetChirpsByAuthor(r.Context(), authorID)
-	} else {
-		// Use = to assign to the existing err variable
-		dbChirps, err = cfg.DB.GetChirps(r.Context())
+	query := r.URL.Query()
+	page, err := parsePageParams(query)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	filters, err := parseChirpFilters(query)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
 	}
 
+	cursorCreatedAt, cursorID := page.cursorArgs()
+	var dbChirps []database.Chirp
+	// Ascending rows come from GetChirpsAfter, descending ones from
+	// GetChirpsBefore; following a prev cursor flips the direction.
+	if filters.ascending != page.backward() {
+		dbChirps, err = cfg.DB.GetChirpsAfter(r.Context(), database.GetChirpsAfterParams{
+			AuthorIds:       filters.authorIDs,
+			Since:           filters.since,
+			Until:           filters.until,
+			Contains:        filters.contains,
+			CursorCreatedAt: cursorCreatedAt,
+			CursorID:        cursorID,
+			Limit:           page.fetchLimit(),
+		})
+	} else {
+		dbChirps, err = cfg.DB.GetChirpsBefore(r.Context(), database.GetChirpsBeforeParams{
+			AuthorIds:       filters.authorIDs,
+			Since:           filters.since,
+			Until:           filters.until,
+			Contains:        filters.contains,
+			CursorCreatedAt: cursorCreatedAt,
+			CursorID:        cursorID,
+			Limit:           page.fetchLimit(),
+		})
+	}
 	if err != nil {
 		log.Printf("Error getting chirps: %s", err)
 		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chirps")
 		return
 	}
 
-	chirps := []Chirp{}
-	for _, dbChirp := range dbChirps {
-		chirps = append(chirps, Chirp{
-			ID:        dbChirp.ID,
-			CreatedAt: dbChirp.CreatedAt,
-			UpdatedAt: dbChirp.UpdatedAt,
-			Body:      dbChirp.Body,
-			UserID:    dbChirp.UserID,
-		})
+	dbChirps, cursors := paginateChirps(dbChirps, page)
+
+	chirps, err := cfg.buildChirps(r.Context(), dbChirps, viewerID)
+	if err != nil {
+		log.Printf("Error building chirps: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chirps")
+		return
 	}
 
-	respondWithJSON(w, http.StatusOK, chirps)
+	setPaginationLinks(w, r, cursors)
+	respondWithJSON(w, http.StatusOK, chirpPage{
+		Chirps:     chirps,
+		NextCursor: cursors.Next,
+		PrevCursor: cursors.Prev,
+	})
+}
+
+type chirpFilters struct {
+	ascending bool
+	authorIDs []uuid.UUID
+	since     sql.NullTime
+	until     sql.NullTime
+	contains  sql.NullString
+}
+
+// parseChirpFilters reads the sort and filter query parameters of the chirps
+// listing. author_id may be repeated or hold a comma-separated list.
+func parseChirpFilters(query url.Values) (chirpFilters, error) {
+	const maxAuthorIDs = 50
+	const maxContainsLength = 140
+
+	filters := chirpFilters{ascending: true}
+
+	switch query.Get("sort") {
+	case "", "asc":
+	case "desc":
+		filters.ascending = false
+	default:
+		return chirpFilters{}, errors.New("sort must be asc or desc")
+	}
+
+	for _, value := range query["author_id"] {
+		for _, authorIDStr := range strings.Split(value, ",") {
+			authorID, err := uuid.Parse(strings.TrimSpace(authorIDStr))
+			if err != nil {
+				return chirpFilters{}, errors.New("invalid author ID")
+			}
+			filters.authorIDs = append(filters.authorIDs, authorID)
+		}
+	}
+	if len(filters.authorIDs) > maxAuthorIDs {
+		return chirpFilters{}, fmt.Errorf("at most %d author IDs are allowed", maxAuthorIDs)
+	}
+
+	for _, bound := range []struct {
+		name string
+		dest *sql.NullTime
+	}{
+		{name: "since", dest: &filters.since},
+		{name: "until", dest: &filters.until},
+	} {
+		value := query.Get(bound.name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return chirpFilters{}, fmt.Errorf("%s must be an RFC 3339 timestamp", bound.name)
+		}
+		*bound.dest = sql.NullTime{Time: t.UTC(), Valid: true}
+	}
+	if filters.since.Valid && filters.until.Valid && !filters.since.Time.Before(filters.until.Time) {
+		return chirpFilters{}, errors.New("since must be before until")
+	}
+
+	if contains := query.Get("contains"); contains != "" {
+		if len(contains) > maxContainsLength {
+			return chirpFilters{}, errors.New("contains filter is too long")
+		}
+		// Match the text literally rather than as an ILIKE pattern.
+		escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(contains)
+		filters.contains = sql.NullString{String: escaped, Valid: true}
+	}
+
+	return filters, nil
 }
 
 func (cfg *apiConfig) handlerWebhookPolka(w http.ResponseWriter, r *http.Request) {
@@ -194,16 +417,7 @@ func (cfg *apiConfig) handlerWebhookPolka(w http.ResponseWriter, r *http.Request
 }
 
 func (cfg *apiConfig) handlerChirpsDelete(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
-		return
-	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
-		return
-	}
+	userID := authenticatedUserID(r)
 
 	chirpIDStr := r.PathValue("chirpID")
 	chirpID, err := uuid.Parse(chirpIDStr)
@@ -236,53 +450,110 @@ func (cfg *apiConfig) handlerChirpsDelete(w http.ResponseWriter, r *http.Request
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// handlerUsersUpdate changes the fields present in the request and leaves
+// the rest alone. Passwords are changed through handlerUsersPasswordChange.
+// Changing the email needs the current password too, since whoever controls
+// the address can reset the password.
 func (cfg *apiConfig) handlerUsersUpdate(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
-		return
-	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
-		return
-	}
+	userID := authenticatedUserID(r)
 
 	type parameters struct {
-		Password string `json:"password"`
-		Email    string `json:"email"`
+		Password        *string `json:"password"`
+		Email           *string `json:"email"`
+		CurrentPassword string  `json:"current_password"`
+		// An empty handle removes it.
+		Handle      *string `json:"handle"`
+		DisplayName *string `json:"display_name"`
+		Bio         *string `json:"bio"`
 	}
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
-	err = decoder.Decode(&params)
+	err := decoder.Decode(&params)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
 
-	hashedPassword, err := auth.HashPassword(params.Password)
+	if params.Password != nil {
+		respondWithError(w, http.StatusBadRequest, "Use POST /api/users/password to change your password")
+		return
+	}
+
+	updateParams := database.UpdateUserParams{
+		ID: userID,
+	}
+	if params.Email != nil {
+		email := strings.TrimSpace(*params.Email)
+		err = validateEmail(email)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid email address")
+			return
+		}
+		updateParams.Email = sql.NullString{String: email, Valid: true}
+	}
+	if updateParams.Email.Valid {
+		user, err := cfg.DB.GetUser(r.Context(), userID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve user")
+			return
+		}
+		if user.Email != updateParams.Email.String {
+			err = auth.CheckPasswordHash(params.CurrentPassword, user.HashedPassword)
+			if err != nil {
+				respondWithError(w, http.StatusUnauthorized, "Incorrect password")
+				return
+			}
+		}
+	}
+	if params.Handle != nil {
+		updateParams.SetHandle = true
+		if *params.Handle != "" {
+			err = validateHandle(*params.Handle)
+			if err != nil {
+				respondWithError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			updateParams.Handle = sql.NullString{String: *params.Handle, Valid: true}
+		}
+	}
+	var displayName, bio string
+	if params.DisplayName != nil {
+		displayName = strings.TrimSpace(*params.DisplayName)
+		updateParams.DisplayName = sql.NullString{String: displayName, Valid: true}
+	}
+	if params.Bio != nil {
+		bio = strings.TrimSpace(*params.Bio)
+		updateParams.Bio = sql.NullString{String: bio, Valid: true}
+	}
+	err = validateProfile(displayName, bio)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password")
+		respondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	user, err := cfg.DB.UpdateUser(r.Context(), database.UpdateUserParams{
-		Email:          params.Email,
-		HashedPassword: hashedPassword,
-		ID:             userID,
-	})
+	user, err := cfg.DB.UpdateUser(r.Context(), updateParams)
 	if err != nil {
+		if constraint, ok := uniqueViolationConstraint(err); ok {
+			if constraint == "users_email_key" {
+				respondWithError(w, http.StatusConflict, "Email is already in use")
+				return
+			}
+			respondWithError(w, http.StatusConflict, "Handle is already taken")
+			return
+		}
 		respondWithError(w, http.StatusInternalServerError, "Couldn't update user")
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, User{
-		ID:          user.ID,
-		CreatedAt:   user.CreatedAt,
-		UpdatedAt:   user.UpdatedAt,
-		Email:       user.Email,
-		IsChirpyRed: user.IsChirpyRed,
-	})
+	// Changing the address clears its verification, so confirm the new one.
+	if updateParams.Email.Valid && !user.EmailVerifiedAt.Valid {
+		err = cfg.sendEmailVerification(r.Context(), user)
+		if err != nil {
+			log.Printf("Error sending verification email to user %s: %s", user.ID, err)
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, userFromDB(user))
 }
 
 func (cfg *apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request) {
@@ -292,7 +563,7 @@ func (cfg *apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = cfg.DB.RevokeRefreshToken(r.Context(), token)
+	err = cfg.DB.RevokeRefreshToken(r.Context(), auth.HashToken(token))
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session")
 		return
@@ -301,9 +572,12 @@ func (cfg *apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// handlerRefresh exchanges a refresh token for a new access token and a new
+// refresh token, revoking the old one.
 func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 	type response struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}
 
 	refreshToken, err := auth.GetBearerToken(r.Header)
@@ -312,35 +586,26 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	dbRefreshToken, err := cfg.DB.GetRefreshToken(r.Context(), refreshToken)
+	// Tokens issued to third-party apps are refreshed at /oauth/token.
+	session, err := cfg.refreshSession(r, refreshToken, uuid.NullUUID{})
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Invalid refresh token")
-		return
-	}
-
-	if dbRefreshToken.RevokedAt.Valid {
-		respondWithError(w, http.StatusUnauthorized, "Refresh token has been revoked")
-		return
-	}
-	if time.Now().UTC().After(dbRefreshToken.ExpiresAt) {
-		respondWithError(w, http.StatusUnauthorized, "Refresh token has expired")
-		return
-	}
-
-	user, err := cfg.DB.GetUserForRefreshToken(r.Context(), refreshToken)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
-		return
-	}
-
-	accessToken, err := auth.MakeJWT(user.ID, cfg.jwtSecret, time.Hour)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create JWT")
+		switch {
+		case errors.Is(err, errInvalidRefreshToken):
+			respondWithError(w, http.StatusUnauthorized, "Invalid refresh token")
+		case errors.Is(err, errRefreshTokenRevoked):
+			respondWithError(w, http.StatusUnauthorized, "Refresh token has been revoked")
+		case errors.Is(err, errRefreshTokenExpired):
+			respondWithError(w, http.StatusUnauthorized, "Refresh token has expired")
+		default:
+			log.Printf("Error refreshing session: %s", err)
+			respondWithError(w, http.StatusInternalServerError, "Couldn't rotate refresh token")
+		}
 		return
 	}
 
 	respondWithJSON(w, http.StatusOK, response{
-		Token: accessToken,
+		Token:        session.AccessToken,
+		RefreshToken: session.RefreshToken,
 	})
 }
 
@@ -349,11 +614,6 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		Password string `json:"password"`
 		Email    string `json:"email"`
 	}
-	type response struct {
-		User
-		Token        string `json:"token"`
-		RefreshToken string `json:"refresh_token"`
-	}
 
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
@@ -363,102 +623,154 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := cfg.DB.GetUserByEmail(r.Context(), params.Email)
+	user, err := cfg.checkCredentials(r.Context(), params.Email, params.Password)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password")
+		if errors.Is(err, errIncorrectCredentials) {
+			respondWithError(w, http.StatusUnauthorized, "Incorrect email or password")
+			return
+		}
+		if errors.Is(err, errEmailNotVerified) {
+			respondWithError(w, http.StatusForbidden, "Email address hasn't been verified")
+			return
+		}
+		log.Printf("Error checking credentials: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't log in")
 		return
 	}
 
-	err = auth.CheckPasswordHash(params.Password, user.HashedPassword)
+	mfaRequired, err := cfg.mfaRequired(r.Context(), user.ID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password")
+		respondWithError(w, http.StatusInternalServerError, "Couldn't log in")
+		return
+	}
+	if mfaRequired {
+		cfg.respondWithMFAChallenge(w, r, user.ID)
 		return
 	}
 
-	accessToken, err := auth.MakeJWT(user.ID, cfg.jwtSecret, time.Hour)
+	cfg.issueSession(w, r, user)
+}
+
+func (cfg *apiConfig) handlerChirpsCreate(w http.ResponseWriter, r *http.Request) {
+	userID := authenticatedUserID(r)
+
+	type parameters struct {
+		Body      string     `json:"body"`
+		InReplyTo *uuid.UUID `json:"in_reply_to"`
+		RechirpOf *uuid.UUID `json:"rechirp_of"`
+		QuoteOf   *uuid.UUID `json:"quote_of"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create access JWT")
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
 		return
 	}
 
-	refreshToken, err := auth.MakeRefreshToken()
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create refresh token")
+	if len(params.Body) > maxChirpLength {
+		respondWithError(w, http.StatusBadRequest, "Chirp is too long")
 		return
 	}
 
-	_, err = cfg.DB.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
-		Token:     refreshToken,
-		UserID:    user.ID,
-		ExpiresAt: time.Now().UTC().Add(60 * 24 * time.Hour),
-	})
+	kind := chirpKindChirp
+	referencedChirpID := uuid.NullUUID{}
+	switch {
+	case params.RechirpOf != nil && params.QuoteOf != nil:
+		respondWithError(w, http.StatusBadRequest, "A chirp can't be both a rechirp and a quote")
+		return
+	case params.RechirpOf != nil:
+		if params.Body != "" || params.InReplyTo != nil {
+			respondWithError(w, http.StatusBadRequest, "A rechirp can't have a body or be a reply")
+			return
+		}
+		kind = chirpKindRechirp
+		referencedChirpID.UUID, err = cfg.resolveReferencedChirp(r.Context(), *params.RechirpOf)
+	case params.QuoteOf != nil:
+		if params.Body == "" {
+			respondWithError(w, http.StatusBadRequest, "A quote needs a body")
+			return
+		}
+		kind = chirpKindQuote
+		referencedChirpID.UUID, err = cfg.resolveReferencedChirp(r.Context(), *params.QuoteOf)
+	}
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't save refresh token")
+		if errors.Is(err, errReferencedChirpNotFound) {
+			respondWithError(w, http.StatusBadRequest, "Chirp being rechirped or quoted doesn't exist")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chirp")
 		return
 	}
+	referencedChirpID.Valid = kind != chirpKindChirp
 
-	respondWithJSON(w, http.StatusOK, response{
-		User: User{
-			ID:          user.ID,
-			CreatedAt:   user.CreatedAt,
-			UpdatedAt:   user.UpdatedAt,
-			Email:       user.Email,
-			IsChirpyRed: user.IsChirpyRed,
-		},
-		Token:        accessToken,
-		RefreshToken: refreshToken,
-	})
-}
+	inReplyTo := uuid.NullUUID{}
+	if params.InReplyTo != nil {
+		_, err = cfg.DB.GetChirp(r.Context(), *params.InReplyTo)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				respondWithError(w, http.StatusBadRequest, "Chirp being replied to doesn't exist")
+				return
+			}
+			respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chirp")
+			return
+		}
+		inReplyTo = uuid.NullUUID{UUID: *params.InReplyTo, Valid: true}
+	}
 
-func (cfg *apiConfig) handlerChirpsCreate(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetBearerToken(r.Header)
+	cleanedBody := cleanProfanity(params.Body)
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create chirp")
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	dbChirp, err := qtx.CreateChirp(r.Context(), database.CreateChirpParams{
+		Body:              cleanedBody,
+		UserID:            userID,
+		InReplyTo:         inReplyTo,
+		Kind:              kind,
+		ReferencedChirpID: referencedChirpID,
+	})
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+		if isUniqueViolation(err) {
+			respondWithError(w, http.StatusConflict, "You've already rechirped this chirp")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create chirp")
 		return
 	}
 
-	type parameters struct {
-		Body string `json:"body"`
-	}
-	decoder := json.NewDecoder(r.Body)
-	params := parameters{}
-	err = decoder.Decode(&params)
+	err = indexChirpTags(r.Context(), qtx, dbChirp)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		log.Printf("Error indexing tags of chirp %s: %s", dbChirp.ID, err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create chirp")
 		return
 	}
 
-	const maxChirpLength = 140
-	if len(params.Body) > maxChirpLength {
-		respondWithError(w, http.StatusBadRequest, "Chirp is too long")
+	err = indexChirpMentions(r.Context(), qtx, dbChirp)
+	if err != nil {
+		log.Printf("Error indexing mentions of chirp %s: %s", dbChirp.ID, err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create chirp")
 		return
 	}
 
-	cleanedBody := cleanProfanity(params.Body)
-
-	chirp, err := cfg.DB.CreateChirp(r.Context(), database.CreateChirpParams{
-		Body:   cleanedBody,
-		UserID: userID,
-	})
+	err = tx.Commit()
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create chirp")
 		return
 	}
 
-	respChirp := Chirp{
-		ID:        chirp.ID,
-		CreatedAt: chirp.CreatedAt,
-		UpdatedAt: chirp.UpdatedAt,
-		Body:      chirp.Body,
-		UserID:    chirp.UserID,
+	chirp, err := cfg.buildChirp(r.Context(), dbChirp, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chirp")
+		return
 	}
 
-	respondWithJSON(w, http.StatusCreated, respChirp)
+	respondWithJSON(w, http.StatusCreated, chirp)
 }
 
 func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request) {
@@ -474,6 +786,16 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	err = validateEmail(params.Email)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid email address")
+		return
+	}
+	if len(params.Password) < minPasswordLength {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Password must be at least %d characters", minPasswordLength))
+		return
+	}
+
 	hashedPassword, err := auth.HashPassword(params.Password)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password")
@@ -485,20 +807,30 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		HashedPassword: hashedPassword,
 	})
 	if err != nil {
+		if isUniqueViolation(err) {
+			respondWithError(w, http.StatusConflict, "Email is already in use")
+			return
+		}
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create user")
 		return
 	}
 
-	respondWithJSON(w, http.StatusCreated, User{
-		ID:          user.ID,
-		CreatedAt:   user.CreatedAt,
-		UpdatedAt:   user.UpdatedAt,
-		Email:       user.Email,
-		IsChirpyRed: user.IsChirpyRed,
-	})
+	// The account exists either way; the user can ask for another link if
+	// this one doesn't arrive.
+	err = cfg.sendEmailVerification(r.Context(), user)
+	if err != nil {
+		log.Printf("Error sending verification email to user %s: %s", user.ID, err)
+	}
+
+	respondWithJSON(w, http.StatusCreated, userFromDB(user))
 }
 
 func (cfg *apiConfig) handlerChirpsGetByID(w http.ResponseWriter, r *http.Request) {
+	viewerID, ok := cfg.optionalViewerID(w, r)
+	if !ok {
+		return
+	}
+
 	chirpIDStr := r.PathValue("chirpID")
 	chirpID, err := uuid.Parse(chirpIDStr)
 	if err != nil {
@@ -516,12 +848,10 @@ func (cfg *apiConfig) handlerChirpsGetByID(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	chirp := Chirp{
-		ID:        dbChirp.ID,
-		CreatedAt: dbChirp.CreatedAt,
-		UpdatedAt: dbChirp.UpdatedAt,
-		Body:      dbChirp.Body,
-		UserID:    dbChirp.UserID,
+	chirp, err := cfg.buildChirp(r.Context(), dbChirp, viewerID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chirp")
+		return
 	}
 
 	respondWithJSON(w, http.StatusOK, chirp)
@@ -604,4 +934,3 @@ func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.WriteHeader(code)
 	w.Write(dat)
 }
-
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/dbfletcher/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const notificationKindMention = "mention"
+
+// mentionRegexp matches an @ that starts a word followed by a handle. As with
+// hashtags, the preceding character is consumed by the first group, which
+// also keeps email addresses like bob@example.com from counting as mentions.
+var mentionRegexp = regexp.MustCompile(`(?:^|[^\p{L}\p{M}\p{N}_@.])@([A-Za-z0-9_]+)`)
+
+type Mention struct {
+	UserID uuid.UUID `json:"user_id"`
+	Handle string    `json:"handle"`
+	// Start and End are offsets into the body in Unicode code points, with
+	// Start at the @ and End just past the handle.
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// mentionMatch is an @handle found in a chirp body, before it has been
+// resolved to a user.
+type mentionMatch struct {
+	handle string
+	start  int
+	end    int
+}
+
+// extractMentions returns every @handle in a chirp body in order, with its
+// position measured in code points.
+func extractMentions(body string) []mentionMatch {
+	matches := []mentionMatch{}
+	for _, loc := range mentionRegexp.FindAllStringSubmatchIndex(body, -1) {
+		// loc[2] is the start of the handle, so the @ is the byte before it.
+		start := utf8.RuneCountInString(body[:loc[2]-1])
+		handle := body[loc[2]:loc[3]]
+		matches = append(matches, mentionMatch{
+			handle: handle,
+			start:  start,
+			end:    start + 1 + utf8.RuneCountInString(handle),
+		})
+	}
+	return matches
+}
+
+// indexChirpMentions replaces the stored mentions of a chirp with the ones in
+// its current body and notifies users who weren't mentioned before. Handles
+// that don't belong to anyone are left as plain text. It should run in the
+// same transaction that wrote the body.
+func indexChirpMentions(ctx context.Context, q *database.Queries, chirp database.Chirp) error {
+	previous, err := q.GetMentionsForChirps(ctx, []uuid.UUID{chirp.ID})
+	if err != nil {
+		return err
+	}
+	alreadyMentioned := make(map[uuid.UUID]bool, len(previous))
+	for _, mention := range previous {
+		alreadyMentioned[mention.UserID] = true
+	}
+
+	err = q.DeleteChirpMentions(ctx, chirp.ID)
+	if err != nil {
+		return err
+	}
+
+	matches := extractMentions(chirp.Body)
+	if len(matches) == 0 {
+		return nil
+	}
+
+	handles := make([]string, 0, len(matches))
+	for _, match := range matches {
+		handles = append(handles, strings.ToLower(match.handle))
+	}
+	users, err := q.GetUsersByHandles(ctx, handles)
+	if err != nil {
+		return err
+	}
+	userIDByHandle := make(map[string]uuid.UUID, len(users))
+	for _, user := range users {
+		userIDByHandle[strings.ToLower(user.Handle.String)] = user.ID
+	}
+
+	mentionedIDs := []uuid.UUID{}
+	for _, match := range matches {
+		userID, ok := userIDByHandle[strings.ToLower(match.handle)]
+		if !ok {
+			continue
+		}
+		err = q.CreateChirpMention(ctx, database.CreateChirpMentionParams{
+			ChirpID:     chirp.ID,
+			UserID:      userID,
+			StartOffset: int32(match.start),
+			EndOffset:   int32(match.end),
+		})
+		if err != nil {
+			return err
+		}
+		mentionedIDs = append(mentionedIDs, userID)
+	}
+
+	for _, userID := range mentionRecipients(chirp.UserID, mentionedIDs, alreadyMentioned) {
+		err = q.CreateNotification(ctx, database.CreateNotificationParams{
+			UserID:  userID,
+			ActorID: chirp.UserID,
+			Kind:    notificationKindMention,
+			ChirpID: uuid.NullUUID{UUID: chirp.ID, Valid: true},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mentionRecipients picks the mentioned users to notify. Mentioning
+// yourself, or someone twice, or someone an earlier version of the chirp
+// already mentioned, doesn't notify again.
+func mentionRecipients(authorID uuid.UUID, mentionedIDs []uuid.UUID, alreadyMentioned map[uuid.UUID]bool) []uuid.UUID {
+	recipients := []uuid.UUID{}
+	notified := map[uuid.UUID]bool{}
+	for _, userID := range mentionedIDs {
+		if userID == authorID || alreadyMentioned[userID] || notified[userID] {
+			continue
+		}
+		notified[userID] = true
+		recipients = append(recipients, userID)
+	}
+	return recipients
+}
+
+// mentionsFromDB converts stored mentions for the chirp with the given body,
+// taking each handle from the body so it keeps the author's capitalisation.
+func mentionsFromDB(body string, dbMentions []database.ChirpMention) []Mention {
+	runes := []rune(body)
+	mentions := make([]Mention, 0, len(dbMentions))
+	for _, dbMention := range dbMentions {
+		start, end := int(dbMention.StartOffset), int(dbMention.EndOffset)
+		if start < 0 || end > len(runes) || start+1 > end {
+			continue
+		}
+		mentions = append(mentions, Mention{
+			UserID: dbMention.UserID,
+			Handle: string(runes[start+1 : end]),
+			Start:  start,
+			End:    end,
+		})
+	}
+	return mentions
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/dbfletcher/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestExtractMentions(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []mentionMatch
+	}{
+		{
+			name: "NoMentions",
+			body: "Just a chirp",
+			want: []mentionMatch{},
+		},
+		{
+			name: "StartOfBody",
+			body: "@alice hi",
+			want: []mentionMatch{{handle: "alice", start: 0, end: 6}},
+		},
+		{
+			name: "EndOfBody",
+			body: "hi @alice",
+			want: []mentionMatch{{handle: "alice", start: 3, end: 9}},
+		},
+		{
+			name: "WholeBody",
+			body: "@alice",
+			want: []mentionMatch{{handle: "alice", start: 0, end: 6}},
+		},
+		{
+			name: "TrailingPunctuation",
+			body: "@alice, @bob! (@carol).",
+			want: []mentionMatch{
+				{handle: "alice", start: 0, end: 6},
+				{handle: "bob", start: 8, end: 12},
+				{handle: "carol", start: 15, end: 21},
+			},
+		},
+		{
+			name: "Emails",
+			body: "Mail a@b.com or bob.smith@example.com",
+			want: []mentionMatch{},
+		},
+		{
+			name: "DoubleAt",
+			body: "@@alice",
+			want: []mentionMatch{},
+		},
+		{
+			name: "Repeated",
+			body: "@alice and @Alice again",
+			want: []mentionMatch{
+				{handle: "alice", start: 0, end: 6},
+				{handle: "Alice", start: 11, end: 17},
+			},
+		},
+		{
+			name: "OffsetsInCodePoints",
+			body: "héllo 👋 @bob",
+			want: []mentionMatch{{handle: "bob", start: 8, end: 12}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := extractMentions(tt.body)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Expected %+v, but got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestMentionRecipients(t *testing.T) {
+	author := uuid.New()
+	alice := uuid.New()
+	bob := uuid.New()
+
+	tests := []struct {
+		name             string
+		mentioned        []uuid.UUID
+		alreadyMentioned map[uuid.UUID]bool
+		want             []uuid.UUID
+	}{
+		{
+			name:      "Mentioned",
+			mentioned: []uuid.UUID{alice, bob},
+			want:      []uuid.UUID{alice, bob},
+		},
+		{
+			name:      "SelfMention",
+			mentioned: []uuid.UUID{author, alice},
+			want:      []uuid.UUID{alice},
+		},
+		{
+			name:      "Repeated",
+			mentioned: []uuid.UUID{alice, bob, alice},
+			want:      []uuid.UUID{alice, bob},
+		},
+		{
+			name:             "AlreadyMentionedBeforeEdit",
+			mentioned:        []uuid.UUID{alice, bob},
+			alreadyMentioned: map[uuid.UUID]bool{alice: true},
+			want:             []uuid.UUID{bob},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mentionRecipients(author, tt.mentioned, tt.alreadyMentioned)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Expected %v, but got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestMentionsFromDB(t *testing.T) {
+	body := "héllo @Bob and @carol"
+	userID := uuid.New()
+
+	dbMentions := []database.ChirpMention{}
+	for _, match := range extractMentions(body) {
+		dbMentions = append(dbMentions, database.ChirpMention{
+			UserID:      userID,
+			StartOffset: int32(match.start),
+			EndOffset:   int32(match.end),
+		})
+	}
+	// Offsets that no longer fit the body are skipped.
+	dbMentions = append(dbMentions, database.ChirpMention{UserID: userID, StartOffset: 20, EndOffset: 30})
+
+	want := []Mention{
+		{UserID: userID, Handle: "Bob", Start: 6, End: 10},
+		{UserID: userID, Handle: "carol", Start: 15, End: 21},
+	}
+	got := mentionsFromDB(body, dbMentions)
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected %+v, but got %+v", want, got)
+	}
+}
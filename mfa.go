@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dbfletcher/chirpy/internal/auth"
+	"github.com/dbfletcher/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	totpIssuer        = "Chirpy"
+	recoveryCodeCount = 10
+	mfaChallengeTTL   = 5 * time.Minute
+	// maxMFAAttempts limits guesses per challenge; after that the user has
+	// to enter their password again.
+	maxMFAAttempts = 5
+)
+
+// mfaRequired reports whether the user has finished enrolling in two-factor
+// authentication.
+func (cfg *apiConfig) mfaRequired(ctx context.Context, userID uuid.UUID) (bool, error) {
+	credential, err := cfg.DB.GetTOTPCredential(ctx, userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	return credential.ConfirmedAt.Valid, nil
+}
+
+// errInvalidMFAChallenge and errInvalidMFACode are returned by
+// completeMFAChallenge when the challenge token or the code is wrong.
+var (
+	errInvalidMFAChallenge = errors.New("invalid or expired MFA challenge")
+	errInvalidMFACode      = errors.New("invalid MFA code")
+)
+
+// createMFAChallenge starts the second step of a login for a user whose
+// password was correct, returning the challenge token.
+func (cfg *apiConfig) createMFAChallenge(ctx context.Context, userID uuid.UUID) (string, time.Time, error) {
+	token, err := auth.MakeToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expiresAt := time.Now().UTC().Add(mfaChallengeTTL)
+	err = cfg.DB.CreateMFAChallenge(ctx, database.CreateMFAChallengeParams{
+		TokenHash: auth.HashToken(token),
+		UserID:    userID,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// respondWithMFAChallenge answers a correct password for a user with
+// two-factor authentication. The token can be exchanged for a session at
+// POST /api/login/mfa together with a code.
+func (cfg *apiConfig) respondWithMFAChallenge(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
+	type response struct {
+		MFARequired bool      `json:"mfa_required"`
+		MFAToken    string    `json:"mfa_token"`
+		ExpiresAt   time.Time `json:"expires_at"`
+	}
+
+	token, expiresAt, err := cfg.createMFAChallenge(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error creating MFA challenge: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create MFA challenge")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		MFARequired: true,
+		MFAToken:    token,
+		ExpiresAt:   expiresAt,
+	})
+}
+
+// completeMFAChallenge checks a code against a challenge from
+// createMFAChallenge and returns the user it was for. Wrong codes count
+// towards maxMFAAttempts.
+func (cfg *apiConfig) completeMFAChallenge(ctx context.Context, token, code, recoveryCode string) (uuid.UUID, error) {
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	// Lock the challenge so parallel guesses are counted one at a time.
+	tokenHash := auth.HashToken(token)
+	challenge, err := qtx.GetMFAChallengeForUpdate(ctx, tokenHash)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return uuid.Nil, errInvalidMFAChallenge
+		}
+		return uuid.Nil, err
+	}
+	if challenge.UsedAt.Valid || time.Now().UTC().After(challenge.ExpiresAt) || challenge.FailedAttempts >= maxMFAAttempts {
+		return uuid.Nil, errInvalidMFAChallenge
+	}
+
+	ok, err := verifySecondFactor(ctx, qtx, challenge.UserID, code, recoveryCode)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if !ok {
+		err = qtx.RecordMFAChallengeFailure(ctx, tokenHash)
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			return uuid.Nil, err
+		}
+		return uuid.Nil, errInvalidMFACode
+	}
+
+	err = qtx.UseMFAChallenge(ctx, tokenHash)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return challenge.UserID, nil
+}
+
+// verifySecondFactor checks either a TOTP code or a recovery code for a user
+// with confirmed two-factor authentication, and uses it up so it can't be
+// presented again.
+func verifySecondFactor(ctx context.Context, q *database.Queries, userID uuid.UUID, code, recoveryCode string) (bool, error) {
+	if recoveryCode != "" {
+		used, err := q.UseRecoveryCode(ctx, database.UseRecoveryCodeParams{
+			UserID:   userID,
+			CodeHash: auth.HashRecoveryCode(recoveryCode),
+		})
+		if err != nil {
+			return false, err
+		}
+		return used == 1, nil
+	}
+
+	credential, err := q.GetTOTPCredential(ctx, userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	if !credential.ConfirmedAt.Valid {
+		return false, nil
+	}
+
+	step, err := auth.ValidateTOTP(credential.Secret, code, time.Now(), credential.LastUsedStep)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidTOTPCode) {
+			return false, nil
+		}
+		return false, err
+	}
+	// Another login may have used the same code since we read the
+	// credential, in which case the step has already moved on.
+	used, err := q.UseTOTPStep(ctx, database.UseTOTPStepParams{
+		UserID:       userID,
+		LastUsedStep: step,
+	})
+	if err != nil {
+		return false, err
+	}
+	return used == 1, nil
+}
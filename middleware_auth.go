@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dbfletcher/chirpy/internal/auth"
+	"github.com/dbfletcher/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+type authContextKey struct{}
+
+// delegatedScopes are the scopes that can be handed to personal API keys
+// and third-party apps. Managing the account itself is left to the user.
+var delegatedScopes = []string{auth.ScopeChirpsWrite, auth.ScopeChirpsDelete}
+
+// principal is the caller of a handler behind middlewareAuth.
+type principal struct {
+	UserID uuid.UUID
+	Scopes []string
+}
+
+// middlewareAuth only lets requests with a valid first-party access token
+// granting all of scopes through to next, which finds the caller with
+// authenticatedUserID.
+func (cfg *apiConfig) middlewareAuth(next http.HandlerFunc, scopes ...string) http.HandlerFunc {
+	return cfg.authenticate(next, false, scopes)
+}
+
+// middlewareAppAuth is middlewareAuth for endpoints that bots and
+// third-party apps may also call, with a personal API key or an access
+// token issued through OAuth.
+func (cfg *apiConfig) middlewareAppAuth(next http.HandlerFunc, scopes ...string) http.HandlerFunc {
+	return cfg.authenticate(next, true, scopes)
+}
+
+func (cfg *apiConfig) authenticate(next http.HandlerFunc, allowApps bool, scopes []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var caller principal
+		if allowApps && strings.HasPrefix(strings.ToLower(r.Header.Get("Authorization")), "apikey ") {
+			var ok bool
+			caller, ok = cfg.authenticateAPIKey(w, r)
+			if !ok {
+				return
+			}
+		} else {
+			token, err := auth.GetBearerToken(r.Header)
+			if err != nil {
+				respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+				return
+			}
+			claims, err := cfg.jwtKeys.ParseJWT(token, auth.TokenTypeAccess)
+			if err != nil {
+				respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+				return
+			}
+			if claims.ClientID != "" && !allowApps {
+				respondWithError(w, http.StatusForbidden, "This endpoint isn't available to apps")
+				return
+			}
+			// ParseJWT has already checked the subject.
+			caller.UserID, _ = claims.UserID()
+			caller.Scopes = claims.Scopes()
+		}
+
+		// Access tokens outlive a deletion request by up to their lifetime,
+		// so check that the account is still active. Logging in again is
+		// how an account in its grace period is restored.
+		_, err := cfg.DB.GetUser(r.Context(), caller.UserID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				respondWithError(w, http.StatusUnauthorized, "Account has been deleted")
+				return
+			}
+			respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve user")
+			return
+		}
+
+		for _, scope := range scopes {
+			if !slices.Contains(caller.Scopes, scope) {
+				respondWithError(w, http.StatusForbidden, fmt.Sprintf("Token is missing the %s scope", scope))
+				return
+			}
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), authContextKey{}, caller)))
+	}
+}
+
+// authenticateAPIKey identifies the caller from a personal API key, writing
+// an error response if the key isn't valid.
+func (cfg *apiConfig) authenticateAPIKey(w http.ResponseWriter, r *http.Request) (principal, bool) {
+	key, err := auth.GetAPIKey(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find API key")
+		return principal{}, false
+	}
+
+	dbKey, err := cfg.DB.GetAPIKeyByHash(r.Context(), auth.HashToken(key))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusUnauthorized, "Invalid API key")
+			return principal{}, false
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't validate API key")
+		return principal{}, false
+	}
+	if dbKey.RevokedAt.Valid {
+		respondWithError(w, http.StatusUnauthorized, "API key has been revoked")
+		return principal{}, false
+	}
+	if dbKey.ExpiresAt.Valid && time.Now().UTC().After(dbKey.ExpiresAt.Time) {
+		respondWithError(w, http.StatusUnauthorized, "API key has expired")
+		return principal{}, false
+	}
+
+	// Writing on every request would be wasteful for a busy bot, so
+	// last_used_at is only moved forward once a minute.
+	err = cfg.DB.TouchAPIKey(r.Context(), dbKey.ID)
+	if err != nil {
+		log.Printf("Error recording use of API key %s: %s", dbKey.ID, err)
+	}
+
+	return principal{UserID: dbKey.UserID, Scopes: dbKey.Scopes}, true
+}
+
+// authenticatedUserID returns the caller of a handler behind middlewareAuth.
+func authenticatedUserID(r *http.Request) uuid.UUID {
+	caller, _ := r.Context().Value(authContextKey{}).(principal)
+	return caller.UserID
+}
+
+// hasScope reports whether the caller of a handler behind middlewareAuth
+// was granted scope.
+func hasScope(r *http.Request, scope string) bool {
+	caller, _ := r.Context().Value(authContextKey{}).(principal)
+	return slices.Contains(caller.Scopes, scope)
+}
+
+// userScopes are the scopes a user's own sessions are granted.
+func userScopes(user database.User) []string {
+	scopes := []string{auth.ScopeChirpsWrite, auth.ScopeChirpsDelete, auth.ScopeUsersWrite}
+	if user.IsAdmin {
+		scopes = append(scopes, auth.ScopeAdmin)
+	}
+	return scopes
+}
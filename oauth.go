@@ -0,0 +1,247 @@
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dbfletcher/chirpy/internal/auth"
+	"github.com/dbfletcher/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	// authorizationCodeTTL is how long an app has to exchange an
+	// authorization code. RFC 6749 recommends at most ten minutes.
+	authorizationCodeTTL = 5 * time.Minute
+	maxOAuthClientName   = 100
+	maxRedirectURIs      = 10
+)
+
+// OAuth error codes from RFC 6749.
+const (
+	oauthErrInvalidRequest          = "invalid_request"
+	oauthErrInvalidClient           = "invalid_client"
+	oauthErrInvalidGrant            = "invalid_grant"
+	oauthErrInvalidScope            = "invalid_scope"
+	oauthErrAccessDenied            = "access_denied"
+	oauthErrServerError             = "server_error"
+	oauthErrUnsupportedGrantType    = "unsupported_grant_type"
+	oauthErrUnsupportedResponseType = "unsupported_response_type"
+)
+
+var errInvalidClient = errors.New("invalid client")
+
+// Errors returned by parseAuthorizationRequest that can't be sent back to
+// the app, because it might not be the one making the request.
+var (
+	errUnknownAuthorizationClient = errors.New("unknown app")
+	errUnregisteredRedirectURI    = errors.New("the redirect URI isn't registered for this app")
+)
+
+// grantedScopes narrows the scopes asked for on behalf of an app to the ones
+// the user still has.
+func grantedScopes(user database.User, requested []string) []string {
+	allowed := userScopes(user)
+	scopes := []string{}
+	for _, scope := range requested {
+		if slices.Contains(allowed, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+// validateRedirectURI checks a redirect URI an app is registering. Apps must
+// use HTTPS, except for native apps listening on the loopback interface.
+func validateRedirectURI(rawURI string) error {
+	u, err := url.Parse(rawURI)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return fmt.Errorf("redirect URI %q must be an absolute URL", rawURI)
+	}
+	if u.Fragment != "" {
+		return fmt.Errorf("redirect URI %q can't have a fragment", rawURI)
+	}
+	switch u.Scheme {
+	case "https":
+		return nil
+	case "http":
+		host := u.Hostname()
+		if host == "localhost" || host == "127.0.0.1" || host == "::1" {
+			return nil
+		}
+	}
+	return fmt.Errorf("redirect URI %q must use https", rawURI)
+}
+
+// authorizationRequest is a validated request to /oauth/authorize.
+type authorizationRequest struct {
+	Client      database.OauthClient
+	RedirectURI string
+	// RedirectURISupplied is false when the app left out redirect_uri and
+	// its only registered URI is used instead.
+	RedirectURISupplied bool
+	State               string
+	Scopes              []string
+	CodeChallenge       string
+}
+
+// oauthRedirectError is an error in an authorization request that is
+// reported to the app through its redirect URI.
+type oauthRedirectError struct {
+	Code        string
+	Description string
+}
+
+func (e *oauthRedirectError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+// parseAuthorizationRequest reads the parameters of /oauth/authorize from the
+// query or, when the consent form is submitted, from the form. Until the
+// client and redirect URI are known to be good, errors are returned as
+// plain errors to show to the user; after that they are oauthRedirectErrors,
+// sent back to the app along with the request.
+func (cfg *apiConfig) parseAuthorizationRequest(r *http.Request) (authorizationRequest, error) {
+	clientID, err := uuid.Parse(r.FormValue("client_id"))
+	if err != nil {
+		return authorizationRequest{}, errUnknownAuthorizationClient
+	}
+	client, err := cfg.DB.GetOAuthClient(r.Context(), clientID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return authorizationRequest{}, errUnknownAuthorizationClient
+		}
+		return authorizationRequest{}, err
+	}
+
+	redirectURI := r.FormValue("redirect_uri")
+	redirectURISupplied := redirectURI != ""
+	if !redirectURISupplied && len(client.RedirectUris) == 1 {
+		redirectURI = client.RedirectUris[0]
+	}
+	if !slices.Contains(client.RedirectUris, redirectURI) {
+		return authorizationRequest{}, errUnregisteredRedirectURI
+	}
+
+	req := authorizationRequest{
+		Client:              client,
+		RedirectURI:         redirectURI,
+		RedirectURISupplied: redirectURISupplied,
+		State:               r.FormValue("state"),
+		CodeChallenge:       r.FormValue("code_challenge"),
+	}
+
+	if r.FormValue("response_type") != "code" {
+		return req, &oauthRedirectError{oauthErrUnsupportedResponseType, "response_type must be code"}
+	}
+	// PKCE is required of every app, confidential or not.
+	if r.FormValue("code_challenge_method") != "S256" {
+		return req, &oauthRedirectError{oauthErrInvalidRequest, "code_challenge_method must be S256"}
+	}
+	if len(req.CodeChallenge) != 43 {
+		return req, &oauthRedirectError{oauthErrInvalidRequest, "code_challenge must be a base64url SHA-256 hash"}
+	}
+
+	req.Scopes = strings.Fields(r.FormValue("scope"))
+	if len(req.Scopes) == 0 {
+		req.Scopes = client.Scopes
+	}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(client.Scopes, scope) {
+			return req, &oauthRedirectError{oauthErrInvalidScope, fmt.Sprintf("this app can't ask for the %s scope", scope)}
+		}
+	}
+
+	return req, nil
+}
+
+// redirectToClient ends an authorization request by sending the user back
+// to the app with params added to its redirect URI.
+func redirectToClient(w http.ResponseWriter, r *http.Request, req authorizationRequest, params url.Values) {
+	u, err := url.Parse(req.RedirectURI)
+	if err != nil {
+		http.Error(w, "Invalid redirect URI", http.StatusInternalServerError)
+		return
+	}
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	query := u.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	u.RawQuery = query.Encode()
+	http.Redirect(w, r, u.String(), http.StatusSeeOther)
+}
+
+// redirectWithOAuthError reports a failed authorization request to the app.
+func redirectWithOAuthError(w http.ResponseWriter, r *http.Request, req authorizationRequest, code, description string) {
+	redirectToClient(w, r, req, url.Values{
+		"error":             {code},
+		"error_description": {description},
+	})
+}
+
+// respondWithOAuthError writes an error from the token or revocation
+// endpoints in the form RFC 6749 defines.
+func respondWithOAuthError(w http.ResponseWriter, code int, errorCode, description string) {
+	type errorResponse struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description,omitempty"`
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if errorCode == oauthErrInvalidClient {
+		w.Header().Set("WWW-Authenticate", `Basic realm="chirpy"`)
+	}
+	respondWithJSON(w, code, errorResponse{
+		Error:            errorCode,
+		ErrorDescription: description,
+	})
+}
+
+// authenticateClient identifies the app calling the token or revocation
+// endpoint, from HTTP Basic credentials or the client_id and client_secret
+// form fields. Public apps have no secret and send only their ID.
+func (cfg *apiConfig) authenticateClient(r *http.Request) (database.OauthClient, error) {
+	clientIDStr, secret, ok := r.BasicAuth()
+	if ok {
+		// Basic credentials are form-encoded before being combined.
+		var err error
+		if clientIDStr, err = url.QueryUnescape(clientIDStr); err != nil {
+			return database.OauthClient{}, errInvalidClient
+		}
+		if secret, err = url.QueryUnescape(secret); err != nil {
+			return database.OauthClient{}, errInvalidClient
+		}
+	} else {
+		clientIDStr = r.PostFormValue("client_id")
+		secret = r.PostFormValue("client_secret")
+	}
+
+	clientID, err := uuid.Parse(clientIDStr)
+	if err != nil {
+		return database.OauthClient{}, errInvalidClient
+	}
+	client, err := cfg.DB.GetOAuthClient(r.Context(), clientID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return database.OauthClient{}, errInvalidClient
+		}
+		return database.OauthClient{}, err
+	}
+
+	if client.SecretHash.Valid {
+		secretHash := auth.HashToken(secret)
+		if secret == "" || subtle.ConstantTimeCompare([]byte(secretHash), []byte(client.SecretHash.String)) != 1 {
+			return database.OauthClient{}, errInvalidClient
+		}
+	}
+	return client, nil
+}
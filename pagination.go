@@ -0,0 +1,192 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/dbfletcher/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// chirpCursor is the decoded form of the opaque cursor handed to clients.
+// It marks a chirp's position in (created_at, id) order and whether the
+// client is paging forwards or backwards from it.
+type chirpCursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        uuid.UUID `json:"id"`
+	Prev      bool      `json:"prev,omitempty"`
+}
+
+func (c chirpCursor) encode() string {
+	dat, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(dat)
+}
+
+func decodeChirpCursor(s string) (chirpCursor, error) {
+	dat, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return chirpCursor{}, err
+	}
+	cursor := chirpCursor{}
+	err = json.Unmarshal(dat, &cursor)
+	if err != nil {
+		return chirpCursor{}, err
+	}
+	if cursor.ID == uuid.Nil || cursor.CreatedAt.IsZero() {
+		return chirpCursor{}, errors.New("incomplete cursor")
+	}
+	return cursor, nil
+}
+
+type pageParams struct {
+	Limit  int32
+	Cursor *chirpCursor
+}
+
+// parsePageParams reads the limit and cursor query parameters.
+func parsePageParams(query url.Values) (pageParams, error) {
+	limit, err := parseLimit(query)
+	if err != nil {
+		return pageParams{}, err
+	}
+	params := pageParams{Limit: limit}
+
+	if cursorStr := query.Get("cursor"); cursorStr != "" {
+		cursor, err := decodeChirpCursor(cursorStr)
+		if err != nil {
+			return pageParams{}, errors.New("invalid cursor")
+		}
+		params.Cursor = &cursor
+	}
+
+	return params, nil
+}
+
+// parseLimit reads the limit query parameter, falling back to the default
+// page size.
+func parseLimit(query url.Values) (int32, error) {
+	limitStr := query.Get("limit")
+	if limitStr == "" {
+		return defaultPageLimit, nil
+	}
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil || limit < 1 || limit > maxPageLimit {
+		return 0, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+	}
+	return int32(limit), nil
+}
+
+// parseOffset reads the offset query parameter used by listings that page by
+// position rather than by cursor.
+func parseOffset(query url.Values) (int32, error) {
+	offsetStr := query.Get("offset")
+	if offsetStr == "" {
+		return 0, nil
+	}
+	offset, err := strconv.ParseInt(offsetStr, 10, 32)
+	if err != nil || offset < 0 {
+		return 0, errors.New("offset must be a non-negative integer")
+	}
+	return int32(offset), nil
+}
+
+// backward reports whether the client followed a prev cursor, in which case
+// rows must be fetched against the display order.
+func (p pageParams) backward() bool {
+	return p.Cursor != nil && p.Cursor.Prev
+}
+
+// fetchLimit asks for one extra row so we can tell whether another page exists.
+func (p pageParams) fetchLimit() int32 {
+	return p.Limit + 1
+}
+
+// cursorArgs returns the cursor position as nullable query arguments.
+func (p pageParams) cursorArgs() (sql.NullTime, uuid.NullUUID) {
+	if p.Cursor == nil {
+		return sql.NullTime{}, uuid.NullUUID{}
+	}
+	return sql.NullTime{Time: p.Cursor.CreatedAt, Valid: true},
+		uuid.NullUUID{UUID: p.Cursor.ID, Valid: true}
+}
+
+type pageCursors struct {
+	Next string
+	Prev string
+}
+
+// paginateChirps trims rows fetched with fetchLimit down to the page size and
+// works out the cursors for the neighbouring pages. Rows must be in fetch
+// order: display order when paging forwards, reversed when paging backwards.
+// The returned rows are always in display order.
+func paginateChirps(dbChirps []database.Chirp, params pageParams) ([]database.Chirp, pageCursors) {
+	hasMore := len(dbChirps) > int(params.Limit)
+	if hasMore {
+		dbChirps = dbChirps[:params.Limit]
+	}
+
+	cursors := pageCursors{}
+	if len(dbChirps) == 0 {
+		return dbChirps, cursors
+	}
+
+	if params.backward() {
+		for i, j := 0, len(dbChirps)-1; i < j; i, j = i+1, j-1 {
+			dbChirps[i], dbChirps[j] = dbChirps[j], dbChirps[i]
+		}
+	}
+
+	// Whichever way we came from, there is a page back in that direction.
+	// In the direction of travel we only know there is more when the extra
+	// row showed up.
+	hasNext, hasPrev := hasMore, params.Cursor != nil
+	if params.backward() {
+		hasNext, hasPrev = true, hasMore
+	}
+
+	if hasNext {
+		last := dbChirps[len(dbChirps)-1]
+		cursors.Next = chirpCursor{CreatedAt: last.CreatedAt, ID: last.ID}.encode()
+	}
+	if hasPrev {
+		first := dbChirps[0]
+		cursors.Prev = chirpCursor{CreatedAt: first.CreatedAt, ID: first.ID, Prev: true}.encode()
+	}
+
+	return dbChirps, cursors
+}
+
+// setPaginationLinks advertises the neighbouring pages in a Link header,
+// keeping every other query parameter of the current request.
+func setPaginationLinks(w http.ResponseWriter, r *http.Request, cursors pageCursors) {
+	addLink := func(rel, cursor string) {
+		if cursor == "" {
+			return
+		}
+		query := r.URL.Query()
+		query.Set("cursor", cursor)
+		u := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+		w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="%s"`, u.String(), rel))
+	}
+	addLink("next", cursors.Next)
+	addLink("prev", cursors.Prev)
+}
+
+// chirpPage is the response envelope for paginated chirp listings.
+type chirpPage struct {
+	Chirps     []Chirp `json:"chirps"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+	PrevCursor string  `json:"prev_cursor,omitempty"`
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+)
+
+const (
+	defaultAccountDeletionGrace = 30 * 24 * time.Hour
+	purgeInterval               = time.Hour
+)
+
+// runPurgeJobs removes data whose retention period has ended, once at
+// startup and then every interval.
+func (cfg *apiConfig) runPurgeJobs(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		ctx := context.Background()
+		cfg.purgeDeletedAccounts(ctx)
+		cfg.purgeExpiredDataExports(ctx)
+		cfg.purgeExpiredOAuthAuthorizationCodes(ctx)
+		<-ticker.C
+	}
+}
+
+// purgeDeletedAccounts hard-deletes accounts whose grace period has ended.
+// Chirps, tokens, follows and the rest of a user's data go with them through
+// ON DELETE CASCADE.
+func (cfg *apiConfig) purgeDeletedAccounts(ctx context.Context) {
+	cutoff := time.Now().UTC().Add(-cfg.accountDeletionGrace)
+	purged, err := cfg.DB.PurgeDeletedUsers(ctx, sql.NullTime{Time: cutoff, Valid: true})
+	if err != nil {
+		log.Printf("Error purging deleted accounts: %s", err)
+		return
+	}
+	if purged > 0 {
+		log.Printf("Purged %d deleted accounts", purged)
+	}
+}
+
+func (cfg *apiConfig) purgeExpiredDataExports(ctx context.Context) {
+	purged, err := cfg.DB.DeleteExpiredDataExports(ctx, time.Now().UTC())
+	if err != nil {
+		log.Printf("Error purging expired data exports: %s", err)
+		return
+	}
+	if purged > 0 {
+		log.Printf("Purged %d expired data exports", purged)
+	}
+}
+
+func (cfg *apiConfig) purgeExpiredOAuthAuthorizationCodes(ctx context.Context) {
+	purged, err := cfg.DB.DeleteExpiredOAuthAuthorizationCodes(ctx, time.Now().UTC())
+	if err != nil {
+		log.Printf("Error purging expired authorization codes: %s", err)
+		return
+	}
+	if purged > 0 {
+		log.Printf("Purged %d expired authorization codes", purged)
+	}
+}
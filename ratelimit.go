@@ -0,0 +1,57 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// addressLimiter allows each key a limited number of events per window. It
+// keeps the unauthenticated endpoints that send email from being used to
+// flood someone's inbox.
+type addressLimiter struct {
+	limit  int
+	window time.Duration
+
+	mu        sync.Mutex
+	events    map[string][]time.Time
+	lastSweep time.Time
+}
+
+func newAddressLimiter(limit int, window time.Duration) *addressLimiter {
+	return &addressLimiter{
+		limit:  limit,
+		window: window,
+		events: map[string][]time.Time{},
+	}
+}
+
+// Allow records an event for key at now and reports whether it is within
+// the limit. Events that aren't allowed aren't counted.
+func (l *addressLimiter) Allow(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Forget keys that have gone quiet, at most once per window, so the map
+	// doesn't grow with every address ever seen.
+	if now.Sub(l.lastSweep) > l.window {
+		for k, times := range l.events {
+			if now.Sub(times[len(times)-1]) > l.window {
+				delete(l.events, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	recent := l.events[key][:0]
+	for _, t := range l.events[key] {
+		if now.Sub(t) < l.window {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= l.limit {
+		l.events[key] = recent
+		return false
+	}
+	l.events[key] = append(recent, now)
+	return true
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAddressLimiter(t *testing.T) {
+	limiter := newAddressLimiter(2, time.Hour)
+	start := time.Now()
+
+	tests := []struct {
+		name string
+		key  string
+		at   time.Duration
+		want bool
+	}{
+		{name: "First", key: "a@example.com", at: 0, want: true},
+		{name: "Second", key: "a@example.com", at: time.Minute, want: true},
+		{name: "OverLimit", key: "a@example.com", at: 2 * time.Minute, want: false},
+		{name: "OtherKey", key: "b@example.com", at: 2 * time.Minute, want: true},
+		{name: "FirstExpired", key: "a@example.com", at: time.Hour, want: true},
+		{name: "StillFull", key: "a@example.com", at: time.Hour + time.Second, want: false},
+		{name: "AllExpired", key: "a@example.com", at: 3 * time.Hour, want: true},
+	}
+
+	// The cases share the limiter, so they run in order.
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := limiter.Allow(tt.key, start.Add(tt.at))
+			if got != tt.want {
+				t.Errorf("Expected %v, but got %v", tt.want, got)
+			}
+		})
+	}
+}
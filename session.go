@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dbfletcher/chirpy/internal/auth"
+	"github.com/dbfletcher/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	accessTokenTTL = time.Hour
+	// refreshTokenTTL is how long a login lasts. Rotating a refresh token
+	// doesn't extend it.
+	refreshTokenTTL = 60 * 24 * time.Hour
+)
+
+// errIncorrectCredentials and errEmailNotVerified are returned by
+// checkCredentials.
+var (
+	errIncorrectCredentials = errors.New("incorrect email or password")
+	errEmailNotVerified     = errors.New("email address hasn't been verified")
+)
+
+type loginResponse struct {
+	User
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Errors returned by refreshSession for refresh tokens that can't be used.
+var (
+	errInvalidRefreshToken = errors.New("invalid refresh token")
+	errRefreshTokenRevoked = errors.New("refresh token has been revoked")
+	errRefreshTokenExpired = errors.New("refresh token has expired")
+)
+
+// checkCredentials returns the user with the email and password, if there is
+// one that may log in. Accounts in their deletion grace period are returned
+// so that logging in can restore them.
+func (cfg *apiConfig) checkCredentials(ctx context.Context, email, password string) (database.User, error) {
+	user, err := cfg.DB.GetUserByEmail(ctx, email)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return database.User{}, errIncorrectCredentials
+		}
+		return database.User{}, err
+	}
+
+	err = auth.CheckPasswordHash(password, user.HashedPassword)
+	if err != nil {
+		return database.User{}, errIncorrectCredentials
+	}
+
+	if cfg.requireEmailVerification && !user.EmailVerifiedAt.Valid {
+		return database.User{}, errEmailNotVerified
+	}
+
+	// An account past its deletion grace period is as good as gone, even if
+	// it hasn't been purged yet.
+	if user.DeletedAt.Valid && time.Since(user.DeletedAt.Time) > cfg.accountDeletionGrace {
+		return database.User{}, errIncorrectCredentials
+	}
+
+	return user, nil
+}
+
+// issueSession finishes a successful login, from handlerLogin or after the
+// second factor, by creating an access token and a refresh token.
+func (cfg *apiConfig) issueSession(w http.ResponseWriter, r *http.Request, user database.User) {
+	// Logging in during the grace period cancels a pending deletion.
+	if user.DeletedAt.Valid {
+		var err error
+		user, err = cfg.DB.RestoreUser(r.Context(), user.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't restore user")
+			return
+		}
+	}
+
+	accessToken, err := cfg.jwtKeys.MakeJWT(user.ID, accessTokenTTL, userScopes(user)...)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create access JWT")
+		return
+	}
+
+	refreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create refresh token")
+		return
+	}
+
+	_, err = cfg.DB.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
+		TokenHash: auth.HashToken(refreshToken),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().UTC().Add(refreshTokenTTL),
+		FamilyID:  uuid.New(),
+		UserAgent: requestUserAgent(r),
+		IpAddress: requestIP(r),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save refresh token")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, loginResponse{
+		User:         userFromDB(user),
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+	})
+}
+
+// maxUserAgentLength stops a client from storing an arbitrarily long header
+// with every refresh token.
+const maxUserAgentLength = 512
+
+// requestUserAgent is the request's User-Agent, cut to maxUserAgentLength
+// bytes without splitting a multi-byte character.
+func requestUserAgent(r *http.Request) string {
+	userAgent := r.UserAgent()
+	if len(userAgent) <= maxUserAgentLength {
+		return userAgent
+	}
+	end := maxUserAgentLength
+	for end > 0 && !utf8.RuneStart(userAgent[end]) {
+		end--
+	}
+	return userAgent[:end]
+}
+
+// requestIP is the address the request came from. Forwarding headers are
+// ignored because anyone can set them.
+func requestIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// makeAccessToken creates an access token for a first-party session, or for
+// the app clientID when it is set.
+func (cfg *apiConfig) makeAccessToken(userID uuid.UUID, clientID uuid.NullUUID, scopes []string) (string, error) {
+	if clientID.Valid {
+		return cfg.jwtKeys.MakeClientJWT(userID, clientID.UUID.String(), accessTokenTTL, scopes...)
+	}
+	return cfg.jwtKeys.MakeJWT(userID, accessTokenTTL, scopes...)
+}
+
+type refreshedSession struct {
+	AccessToken  string
+	RefreshToken string
+	Scopes       []string
+}
+
+// refreshSession exchanges a refresh token for a new access token and a new
+// refresh token, revoking the old one and marking it rotated. clientID must be the app the token
+// was issued to, or null for a first-party session. The new token keeps the
+// expiry of the login it descends from, so refreshing can't keep a session
+// alive forever.
+func (cfg *apiConfig) refreshSession(r *http.Request, refreshToken string, clientID uuid.NullUUID) (refreshedSession, error) {
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		return refreshedSession{}, err
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	// Lock the token so two concurrent refreshes can't both rotate it.
+	dbRefreshToken, err := qtx.GetRefreshTokenForUpdate(r.Context(), auth.HashToken(refreshToken))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return refreshedSession{}, errInvalidRefreshToken
+		}
+		return refreshedSession{}, err
+	}
+	if dbRefreshToken.ClientID != clientID {
+		return refreshedSession{}, errInvalidRefreshToken
+	}
+
+	if dbRefreshToken.RotatedAt.Valid {
+		// A rotated token should never be sent again. If it is, either it
+		// or its replacement has been stolen, and we can't tell which
+		// holder is legitimate, so end the whole session.
+		err = qtx.RevokeRefreshTokenFamily(r.Context(), dbRefreshToken.FamilyID)
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			return refreshedSession{}, fmt.Errorf("revoking refresh token family %s: %w", dbRefreshToken.FamilyID, err)
+		}
+		log.Printf("SECURITY: rotated refresh token reused for user %s, revoked token family %s (remote address %s)",
+			dbRefreshToken.UserID, dbRefreshToken.FamilyID, r.RemoteAddr)
+		return refreshedSession{}, errRefreshTokenRevoked
+	}
+	if dbRefreshToken.RevokedAt.Valid {
+		// Logging out, changing the password or ending the session revokes
+		// a token without rotating it. Sending it again is expected from a
+		// client that hasn't noticed, not a sign of theft.
+		return refreshedSession{}, errRefreshTokenRevoked
+	}
+	if time.Now().UTC().After(dbRefreshToken.ExpiresAt) {
+		return refreshedSession{}, errRefreshTokenExpired
+	}
+
+	// Scopes are worked out again on every refresh, so losing admin rights
+	// takes effect within one access token lifetime.
+	user, err := qtx.GetUser(r.Context(), dbRefreshToken.UserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return refreshedSession{}, errInvalidRefreshToken
+		}
+		return refreshedSession{}, err
+	}
+	scopes := userScopes(user)
+	if clientID.Valid {
+		scopes = grantedScopes(user, dbRefreshToken.Scopes)
+	}
+
+	err = qtx.RotateRefreshToken(r.Context(), dbRefreshToken.TokenHash)
+	if err != nil {
+		return refreshedSession{}, err
+	}
+
+	newRefreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		return refreshedSession{}, err
+	}
+	_, err = qtx.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
+		TokenHash: auth.HashToken(newRefreshToken),
+		UserID:    dbRefreshToken.UserID,
+		ExpiresAt: dbRefreshToken.ExpiresAt,
+		FamilyID:  dbRefreshToken.FamilyID,
+		UserAgent: requestUserAgent(r),
+		IpAddress: requestIP(r),
+		ClientID:  dbRefreshToken.ClientID,
+		Scopes:    dbRefreshToken.Scopes,
+	})
+	if err != nil {
+		return refreshedSession{}, err
+	}
+
+	accessToken, err := cfg.makeAccessToken(user.ID, clientID, scopes)
+	if err != nil {
+		return refreshedSession{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return refreshedSession{}, err
+	}
+
+	return refreshedSession{
+		AccessToken:  accessToken,
+		RefreshToken: newRefreshToken,
+		Scopes:       scopes,
+	}, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestRequestUserAgent(t *testing.T) {
+	tests := []struct {
+		name      string
+		userAgent string
+		want      string
+	}{
+		{
+			name:      "Short",
+			userAgent: "curl/8.5.0",
+			want:      "curl/8.5.0",
+		},
+		{
+			name:      "ExactlyMax",
+			userAgent: strings.Repeat("a", maxUserAgentLength),
+			want:      strings.Repeat("a", maxUserAgentLength),
+		},
+		{
+			name:      "TooLong",
+			userAgent: strings.Repeat("a", maxUserAgentLength+10),
+			want:      strings.Repeat("a", maxUserAgentLength),
+		},
+		{
+			name:      "MultiByteAcrossLimit",
+			userAgent: strings.Repeat("a", maxUserAgentLength-1) + "é",
+			want:      strings.Repeat("a", maxUserAgentLength-1),
+		},
+		{
+			name:      "FourByteAcrossLimit",
+			userAgent: strings.Repeat("a", maxUserAgentLength-2) + "🐦",
+			want:      strings.Repeat("a", maxUserAgentLength-2),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/login", nil)
+			r.Header.Set("User-Agent", tt.userAgent)
+			got := requestUserAgent(r)
+			if got != tt.want {
+				t.Errorf("Expected %d bytes, but got %d", len(tt.want), len(got))
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("Expected valid UTF-8, but got %q", got)
+			}
+		})
+	}
+}
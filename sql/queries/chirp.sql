@@ -1,15 +1,34 @@
 -- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
-VALUES (gen_random_uuid(), NOW(), NOW(), $1, $2)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, in_reply_to, kind, referenced_chirp_id, is_reply)
+VALUES (gen_random_uuid(), NOW(), NOW(), $1, $2, $3, $4, $5, $3::uuid IS NOT NULL)
 RETURNING *;
 
 -- name: GetChirps :many
-SELECT * FROM chirps
-ORDER BY created_at ASC;
+SELECT chirps.* FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE users.deleted_at IS NULL
+ORDER BY chirps.created_at ASC;
 
 -- name: GetChirp :one
+SELECT chirps.* FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE chirps.id = $1 AND users.deleted_at IS NULL;
+
+-- name: GetChirpsByIDs :many
+SELECT chirps.* FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE chirps.id = ANY(sqlc.arg('ids')::uuid[]) AND users.deleted_at IS NULL;
+
+-- name: GetChirpForUpdate :one
 SELECT * FROM chirps
-WHERE id = $1;
+WHERE id = $1
+FOR UPDATE;
+
+-- name: UpdateChirpBody :one
+UPDATE chirps
+SET body = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
 
 -- name: DeleteChirp :exec
 DELETE FROM chirps
@@ -19,3 +38,87 @@ WHERE id = $1;
 SELECT * FROM chirps
 WHERE user_id = $1
 ORDER BY created_at ASC;
+
+-- name: CountChirpsByAuthor :one
+SELECT COUNT(*) FROM chirps
+WHERE user_id = $1;
+
+-- name: GetChirpsAfter :many
+SELECT chirps.* FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE users.deleted_at IS NULL
+  AND (sqlc.narg('author_ids')::uuid[] IS NULL OR chirps.user_id = ANY(sqlc.narg('author_ids')::uuid[]))
+  AND (sqlc.narg('since')::timestamp IS NULL OR chirps.created_at >= sqlc.narg('since')::timestamp)
+  AND (sqlc.narg('until')::timestamp IS NULL OR chirps.created_at < sqlc.narg('until')::timestamp)
+  AND (sqlc.narg('contains')::text IS NULL OR chirps.body ILIKE '%' || sqlc.narg('contains')::text || '%')
+  AND (sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (chirps.created_at, chirps.id) > (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid))
+ORDER BY chirps.created_at ASC, chirps.id ASC
+LIMIT sqlc.arg('limit');
+
+-- name: GetChirpsBefore :many
+SELECT chirps.* FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE users.deleted_at IS NULL
+  AND (sqlc.narg('author_ids')::uuid[] IS NULL OR chirps.user_id = ANY(sqlc.narg('author_ids')::uuid[]))
+  AND (sqlc.narg('since')::timestamp IS NULL OR chirps.created_at >= sqlc.narg('since')::timestamp)
+  AND (sqlc.narg('until')::timestamp IS NULL OR chirps.created_at < sqlc.narg('until')::timestamp)
+  AND (sqlc.narg('contains')::text IS NULL OR chirps.body ILIKE '%' || sqlc.narg('contains')::text || '%')
+  AND (sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (chirps.created_at, chirps.id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid))
+ORDER BY chirps.created_at DESC, chirps.id DESC
+LIMIT sqlc.arg('limit');
+
+-- name: SearchChirps :many
+SELECT sqlc.embed(chirps),
+    ts_rank(to_tsvector('english', chirps.body), websearch_to_tsquery('english', sqlc.arg('query')::text))::real AS rank,
+    ts_headline('english', chirps.body, websearch_to_tsquery('english', sqlc.arg('query')::text),
+        'StartSel=<mark>, StopSel=</mark>, HighlightAll=true')::text AS headline
+FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE to_tsvector('english', chirps.body) @@ websearch_to_tsquery('english', sqlc.arg('query')::text)
+  AND users.deleted_at IS NULL
+ORDER BY rank DESC, chirps.created_at DESC, chirps.id DESC
+LIMIT sqlc.arg('limit') OFFSET sqlc.arg('offset');
+
+-- name: CountRepliesForChirps :many
+SELECT chirps.in_reply_to::uuid AS chirp_id, COUNT(*) AS reply_count
+FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE chirps.in_reply_to = ANY(sqlc.arg('chirp_ids')::uuid[]) AND users.deleted_at IS NULL
+GROUP BY chirps.in_reply_to;
+
+-- name: GetChirpAncestors :many
+WITH RECURSIVE ancestors AS (
+    SELECT parent.id, parent.in_reply_to, 1 AS depth
+    FROM chirps child
+    JOIN chirps parent ON parent.id = child.in_reply_to
+    JOIN users ON users.id = parent.user_id
+    WHERE child.id = sqlc.arg('id')::uuid AND users.deleted_at IS NULL
+    UNION ALL
+    SELECT parent.id, parent.in_reply_to, ancestors.depth + 1
+    FROM ancestors
+    JOIN chirps parent ON parent.id = ancestors.in_reply_to
+    JOIN users ON users.id = parent.user_id
+    WHERE ancestors.depth < sqlc.arg('max_depth')::int AND users.deleted_at IS NULL
+)
+SELECT chirps.* FROM chirps
+JOIN ancestors ON chirps.id = ancestors.id
+ORDER BY ancestors.depth DESC;
+
+-- name: GetChirpDescendants :many
+WITH RECURSIVE descendants AS (
+    SELECT chirps.id, 1 AS depth
+    FROM chirps
+    JOIN users ON users.id = chirps.user_id
+    WHERE chirps.in_reply_to = sqlc.arg('id')::uuid AND users.deleted_at IS NULL
+    UNION ALL
+    SELECT chirps.id, descendants.depth + 1
+    FROM descendants
+    JOIN chirps ON chirps.in_reply_to = descendants.id
+    JOIN users ON users.id = chirps.user_id
+    WHERE descendants.depth < sqlc.arg('max_depth')::int AND users.deleted_at IS NULL
+)
+SELECT chirps.* FROM chirps
+JOIN descendants ON chirps.id = descendants.id
+ORDER BY chirps.created_at ASC, chirps.id ASC;
@@ -0,0 +1,36 @@
+-- name: CreateDataExport :one
+INSERT INTO data_exports (id, user_id, status, created_at, updated_at, expires_at)
+VALUES (gen_random_uuid(), $1, 'pending', NOW(), NOW(), $2)
+RETURNING *;
+
+-- name: GetDataExport :one
+SELECT * FROM data_exports
+WHERE id = $1 AND user_id = $2;
+
+-- name: GetPendingDataExport :one
+SELECT * FROM data_exports
+WHERE user_id = $1 AND status = 'pending' AND created_at > sqlc.arg('created_after')
+ORDER BY created_at DESC
+LIMIT 1;
+
+-- name: CompleteDataExport :exec
+UPDATE data_exports
+SET status = 'ready', completed_at = NOW(), updated_at = NOW()
+WHERE id = $1;
+
+-- name: FailDataExport :exec
+UPDATE data_exports
+SET status = 'failed', completed_at = NOW(), updated_at = NOW()
+WHERE id = $1;
+
+-- name: CreateDataExportFile :exec
+INSERT INTO data_export_files (data_export_id, json_data, zip_data)
+VALUES ($1, $2, $3);
+
+-- name: GetDataExportFile :one
+SELECT * FROM data_export_files
+WHERE data_export_id = $1;
+
+-- name: DeleteExpiredDataExports :execrows
+DELETE FROM data_exports
+WHERE expires_at < $1;
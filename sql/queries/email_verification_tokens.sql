@@ -0,0 +1,12 @@
+-- name: CreateEmailVerificationToken :exec
+INSERT INTO email_verification_tokens (token_hash, user_id, email, created_at, expires_at)
+VALUES ($1, $2, $3, NOW(), $4);
+
+-- name: GetEmailVerificationToken :one
+SELECT * FROM email_verification_tokens
+WHERE token_hash = $1;
+
+-- name: UseEmailVerificationToken :exec
+UPDATE email_verification_tokens
+SET used_at = NOW()
+WHERE token_hash = $1;
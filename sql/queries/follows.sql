@@ -0,0 +1,62 @@
+-- name: FollowUser :exec
+INSERT INTO follows (follower_id, followee_id, created_at)
+VALUES ($1, $2, NOW())
+ON CONFLICT DO NOTHING;
+
+-- name: UnfollowUser :exec
+DELETE FROM follows
+WHERE follower_id = $1 AND followee_id = $2;
+
+-- name: GetFollowers :many
+SELECT follows.* FROM follows
+JOIN users ON users.id = follows.follower_id
+WHERE follows.followee_id = $1 AND users.deleted_at IS NULL
+ORDER BY follows.created_at DESC
+LIMIT $2 OFFSET $3;
+
+-- name: GetFollowing :many
+SELECT follows.* FROM follows
+JOIN users ON users.id = follows.followee_id
+WHERE follows.follower_id = $1 AND users.deleted_at IS NULL
+ORDER BY follows.created_at DESC
+LIMIT $2 OFFSET $3;
+
+-- name: CountFollowers :one
+SELECT COUNT(*) FROM follows
+JOIN users ON users.id = follows.follower_id
+WHERE follows.followee_id = $1 AND users.deleted_at IS NULL;
+
+-- name: CountFollowing :one
+SELECT COUNT(*) FROM follows
+JOIN users ON users.id = follows.followee_id
+WHERE follows.follower_id = $1 AND users.deleted_at IS NULL;
+
+-- name: GetTimelineAfter :many
+SELECT chirps.* FROM chirps
+JOIN follows ON follows.followee_id = chirps.user_id
+JOIN users ON users.id = chirps.user_id
+WHERE follows.follower_id = sqlc.arg('user_id') AND users.deleted_at IS NULL
+  AND (sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (chirps.created_at, chirps.id) > (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid))
+ORDER BY chirps.created_at ASC, chirps.id ASC
+LIMIT sqlc.arg('limit');
+
+-- name: GetTimelineBefore :many
+SELECT chirps.* FROM chirps
+JOIN follows ON follows.followee_id = chirps.user_id
+JOIN users ON users.id = chirps.user_id
+WHERE follows.follower_id = sqlc.arg('user_id') AND users.deleted_at IS NULL
+  AND (sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (chirps.created_at, chirps.id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid))
+ORDER BY chirps.created_at DESC, chirps.id DESC
+LIMIT sqlc.arg('limit');
+
+-- name: GetFollowsByFollower :many
+SELECT * FROM follows
+WHERE follower_id = $1
+ORDER BY created_at ASC;
+
+-- name: GetFollowsByFollowee :many
+SELECT * FROM follows
+WHERE followee_id = $1
+ORDER BY created_at ASC;
@@ -0,0 +1,36 @@
+-- name: LikeChirp :exec
+INSERT INTO chirp_likes (chirp_id, user_id, created_at)
+VALUES ($1, $2, NOW())
+ON CONFLICT DO NOTHING;
+
+-- name: UnlikeChirp :exec
+DELETE FROM chirp_likes
+WHERE chirp_id = $1 AND user_id = $2;
+
+-- name: GetChirpLikes :many
+SELECT chirp_likes.* FROM chirp_likes
+JOIN users ON users.id = chirp_likes.user_id
+WHERE chirp_likes.chirp_id = $1 AND users.deleted_at IS NULL
+ORDER BY chirp_likes.created_at DESC
+LIMIT $2 OFFSET $3;
+
+-- name: CountChirpLikes :one
+SELECT COUNT(*) FROM chirp_likes
+JOIN users ON users.id = chirp_likes.user_id
+WHERE chirp_likes.chirp_id = $1 AND users.deleted_at IS NULL;
+
+-- name: CountLikesForChirps :many
+SELECT chirp_likes.chirp_id, COUNT(*) AS like_count
+FROM chirp_likes
+JOIN users ON users.id = chirp_likes.user_id
+WHERE chirp_likes.chirp_id = ANY(sqlc.arg('chirp_ids')::uuid[]) AND users.deleted_at IS NULL
+GROUP BY chirp_likes.chirp_id;
+
+-- name: GetLikedChirpIDs :many
+SELECT chirp_id FROM chirp_likes
+WHERE user_id = sqlc.arg('user_id') AND chirp_id = ANY(sqlc.arg('chirp_ids')::uuid[]);
+
+-- name: GetLikesByUser :many
+SELECT * FROM chirp_likes
+WHERE user_id = $1
+ORDER BY created_at ASC;
@@ -0,0 +1,18 @@
+-- name: CreateMFAChallenge :exec
+INSERT INTO mfa_challenges (token_hash, user_id, created_at, expires_at)
+VALUES ($1, $2, NOW(), $3);
+
+-- name: GetMFAChallengeForUpdate :one
+SELECT * FROM mfa_challenges
+WHERE token_hash = $1
+FOR UPDATE;
+
+-- name: RecordMFAChallengeFailure :exec
+UPDATE mfa_challenges
+SET failed_attempts = failed_attempts + 1
+WHERE token_hash = $1;
+
+-- name: UseMFAChallenge :exec
+UPDATE mfa_challenges
+SET used_at = NOW()
+WHERE token_hash = $1;
@@ -0,0 +1,15 @@
+-- name: CreateNotification :exec
+INSERT INTO notifications (id, created_at, user_id, actor_id, kind, chirp_id)
+VALUES (gen_random_uuid(), NOW(), $1, $2, $3, $4);
+
+-- name: GetNotifications :many
+SELECT notifications.* FROM notifications
+JOIN users ON users.id = notifications.actor_id
+WHERE notifications.user_id = $1 AND users.deleted_at IS NULL
+ORDER BY notifications.created_at DESC, notifications.id DESC
+LIMIT $2 OFFSET $3;
+
+-- name: GetAllNotificationsForUser :many
+SELECT * FROM notifications
+WHERE user_id = $1
+ORDER BY created_at ASC;
@@ -0,0 +1,52 @@
+-- name: UpsertTag :one
+INSERT INTO tags (id, name, created_at)
+VALUES (gen_random_uuid(), $1, NOW())
+ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name
+RETURNING *;
+
+-- name: AddChirpTag :exec
+INSERT INTO chirp_tags (chirp_id, tag_id, created_at)
+VALUES ($1, $2, $3)
+ON CONFLICT DO NOTHING;
+
+-- name: DeleteChirpTags :exec
+DELETE FROM chirp_tags
+WHERE chirp_id = $1;
+
+-- name: GetChirpsByTagAfter :many
+SELECT chirps.* FROM chirps
+JOIN chirp_tags ON chirp_tags.chirp_id = chirps.id
+JOIN tags ON tags.id = chirp_tags.tag_id
+JOIN users ON users.id = chirps.user_id
+WHERE tags.name = sqlc.arg('tag') AND users.deleted_at IS NULL
+  AND (sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (chirps.created_at, chirps.id) > (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid))
+ORDER BY chirps.created_at ASC, chirps.id ASC
+LIMIT sqlc.arg('limit');
+
+-- name: GetChirpsByTagBefore :many
+SELECT chirps.* FROM chirps
+JOIN chirp_tags ON chirp_tags.chirp_id = chirps.id
+JOIN tags ON tags.id = chirp_tags.tag_id
+JOIN users ON users.id = chirps.user_id
+WHERE tags.name = sqlc.arg('tag') AND users.deleted_at IS NULL
+  AND (sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (chirps.created_at, chirps.id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid))
+ORDER BY chirps.created_at DESC, chirps.id DESC
+LIMIT sqlc.arg('limit');
+
+-- name: GetTrendingTags :many
+SELECT name, current_count, previous_count FROM (
+    SELECT tags.name,
+        COUNT(*) FILTER (WHERE chirp_tags.created_at >= sqlc.arg('window_start')::timestamp) AS current_count,
+        COUNT(*) FILTER (WHERE chirp_tags.created_at < sqlc.arg('window_start')::timestamp) AS previous_count
+    FROM chirp_tags
+    JOIN tags ON tags.id = chirp_tags.tag_id
+    JOIN chirps ON chirps.id = chirp_tags.chirp_id
+    JOIN users ON users.id = chirps.user_id
+    WHERE chirp_tags.created_at >= sqlc.arg('previous_window_start')::timestamp AND users.deleted_at IS NULL
+    GROUP BY tags.name
+) counts
+WHERE current_count > 0
+ORDER BY current_count - previous_count DESC, current_count DESC, name ASC
+LIMIT sqlc.arg('limit');
@@ -9,10 +9,20 @@ WHERE email = $1;
 
 -- name: UpdateUser :one
 UPDATE users
-SET email = $1, hashed_password = $2, updated_at = NOW()
-WHERE id = $3
+SET email = COALESCE(sqlc.narg('email'), email),
+    email_verified_at = CASE WHEN sqlc.narg('email') IS NULL OR sqlc.narg('email') = email THEN email_verified_at END,
+    handle = CASE WHEN sqlc.arg('set_handle')::bool THEN sqlc.narg('handle') ELSE handle END,
+    display_name = COALESCE(sqlc.narg('display_name'), display_name),
+    bio = COALESCE(sqlc.narg('bio'), bio),
+    updated_at = NOW()
+WHERE id = sqlc.arg('id')
 RETURNING *;
 
+-- name: UpdateUserPassword :exec
+UPDATE users
+SET hashed_password = $2, updated_at = NOW()
+WHERE id = $1;
+
 -- name: UpgradeUserToChirpyRed :one
 UPDATE users
 SET is_chirpy_red = TRUE, updated_at = NOW()
@@ -22,3 +32,39 @@ RETURNING *;
 -- name: DeleteUsers :exec
 DELETE FROM users;
 
+
+-- name: GetUser :one
+SELECT * FROM users
+WHERE id = $1 AND deleted_at IS NULL;
+
+-- name: GetUsersByHandles :many
+SELECT * FROM users
+WHERE LOWER(handle) = ANY(sqlc.arg('handles')::text[]) AND deleted_at IS NULL;
+
+-- name: GetUserByHandle :one
+SELECT * FROM users
+WHERE LOWER(handle) = LOWER(sqlc.arg('handle')) AND deleted_at IS NULL;
+
+-- name: SoftDeleteUser :exec
+UPDATE users
+SET deleted_at = NOW(), updated_at = NOW()
+WHERE id = $1;
+
+-- name: RestoreUser :one
+UPDATE users
+SET deleted_at = NULL, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: PurgeDeletedUsers :execrows
+DELETE FROM users
+WHERE deleted_at < $1;
+
+-- name: VerifyUserEmail :execrows
+UPDATE users
+SET email_verified_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND email = $2;
+
+-- name: GetUserForLogin :one
+SELECT * FROM users
+WHERE id = $1;
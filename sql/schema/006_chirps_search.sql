@@ -0,0 +1,7 @@
+-- +goose Up
+-- Search matches against an expression index rather than a stored tsvector
+-- column, so reading a chirp doesn't load the vector.
+CREATE INDEX chirps_search_idx ON chirps USING GIN (to_tsvector('english', body));
+
+-- +goose Down
+DROP INDEX chirps_search_idx;
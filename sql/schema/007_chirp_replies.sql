@@ -0,0 +1,14 @@
+-- +goose Up
+-- in_reply_to is nulled when the parent is deleted. is_reply remembers that
+-- the chirp was a reply, so it isn't mistaken for the start of a thread.
+ALTER TABLE chirps
+ADD COLUMN in_reply_to UUID REFERENCES chirps(id) ON DELETE SET NULL,
+ADD COLUMN is_reply BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX chirps_in_reply_to_idx ON chirps (in_reply_to);
+
+-- +goose Down
+DROP INDEX chirps_in_reply_to_idx;
+ALTER TABLE chirps
+DROP COLUMN is_reply,
+DROP COLUMN in_reply_to;
@@ -0,0 +1,25 @@
+-- +goose Up
+CREATE TABLE data_exports (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status TEXT NOT NULL CHECK (status IN ('pending', 'ready', 'failed')),
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    completed_at TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX data_exports_user_id_idx ON data_exports (user_id, created_at);
+CREATE INDEX data_exports_expires_at_idx ON data_exports (expires_at);
+
+-- The archives live in their own table so polling an export's status
+-- doesn't read them.
+CREATE TABLE data_export_files (
+    data_export_id UUID PRIMARY KEY REFERENCES data_exports(id) ON DELETE CASCADE,
+    json_data BYTEA NOT NULL,
+    zip_data BYTEA NOT NULL
+);
+
+-- +goose Down
+DROP TABLE data_export_files;
+DROP TABLE data_exports;
@@ -0,0 +1,20 @@
+-- +goose Up
+-- Every refresh token descends from a login. Refreshing replaces the token
+-- with a new one in the same family and sets rotated_at on the old one, so
+-- a rotated token turning up again means it was stolen, and the whole
+-- family is revoked. Tokens revoked any other way, such as by logging out,
+-- have no rotated_at.
+ALTER TABLE refresh_tokens
+ADD COLUMN family_id UUID,
+ADD COLUMN rotated_at TIMESTAMP;
+
+UPDATE refresh_tokens SET family_id = gen_random_uuid();
+
+ALTER TABLE refresh_tokens ALTER COLUMN family_id SET NOT NULL;
+
+CREATE INDEX refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+
+-- +goose Down
+ALTER TABLE refresh_tokens
+DROP COLUMN rotated_at,
+DROP COLUMN family_id;
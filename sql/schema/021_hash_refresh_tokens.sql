@@ -0,0 +1,11 @@
+-- +goose Up
+-- Existing tokens are converted in place, so current sessions keep working.
+UPDATE refresh_tokens SET token = encode(sha256(convert_to(token, 'UTF8')), 'hex');
+
+ALTER TABLE refresh_tokens RENAME COLUMN token TO token_hash;
+
+-- +goose Down
+-- Hashes can't be turned back into tokens, so every session ends.
+DELETE FROM refresh_tokens;
+
+ALTER TABLE refresh_tokens RENAME COLUMN token_hash TO token;
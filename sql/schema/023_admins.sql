@@ -0,0 +1,6 @@
+-- +goose Up
+-- Admins are appointed directly in the database; there is no endpoint for it.
+ALTER TABLE users ADD COLUMN is_admin BOOLEAN NOT NULL DEFAULT false;
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN is_admin;
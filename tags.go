@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/dbfletcher/chirpy/internal/database"
+	"golang.org/x/text/unicode/norm"
+)
+
+// maxTagLength is the longest hashtag, in characters, that gets indexed.
+const maxTagLength = 50
+
+// hashtagRegexp matches a # that starts a word, followed by letters, marks,
+// digits or underscores in any script. A # straight after a slash is a URL
+// fragment rather than a tag. Go's regexp has no lookbehind, so the
+// preceding character (if any) is consumed by the first group.
+var hashtagRegexp = regexp.MustCompile(`(?:^|[^\p{L}\p{M}\p{N}_&#/])#([\p{L}\p{M}\p{N}_]+)`)
+
+// extractHashtags returns the distinct, normalized hashtags in a chirp body
+// in the order they first appear. Tags made only of digits and underscores,
+// like #1, and tags longer than maxTagLength are ignored.
+func extractHashtags(body string) []string {
+	tags := []string{}
+	seen := map[string]bool{}
+	for _, match := range hashtagRegexp.FindAllStringSubmatch(body, -1) {
+		tag := normalizeTag(match[1])
+		if seen[tag] || !strings.ContainsFunc(tag, unicode.IsLetter) || utf8.RuneCountInString(tag) > maxTagLength {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// normalizeTag turns a tag from a URL, with or without its leading #, into
+// the form it is stored in. Composing accents first means tags that look the
+// same are the same, however they were typed.
+func normalizeTag(tag string) string {
+	return strings.ToLower(norm.NFC.String(strings.TrimPrefix(tag, "#")))
+}
+
+// indexChirpTags replaces the stored hashtags of a chirp with the ones in its
+// current body. It should run in the same transaction that wrote the body.
+func indexChirpTags(ctx context.Context, q *database.Queries, chirp database.Chirp) error {
+	err := q.DeleteChirpTags(ctx, chirp.ID)
+	if err != nil {
+		return err
+	}
+	for _, name := range extractHashtags(chirp.Body) {
+		tag, err := q.UpsertTag(ctx, name)
+		if err != nil {
+			return err
+		}
+		// Tags keep the chirp's timestamp so editing an old chirp doesn't
+		// make its tags look like they're trending.
+		err = q.AddChirpTag(ctx, database.AddChirpTagParams{
+			ChirpID:   chirp.ID,
+			TagID:     tag.ID,
+			CreatedAt: chirp.CreatedAt,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
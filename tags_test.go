@@ -0,0 +1,131 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestExtractHashtags(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{
+			name: "NoTags",
+			body: "Just a chirp",
+			want: []string{},
+		},
+		{
+			name: "LowerCased",
+			body: "I love #Go",
+			want: []string{"go"},
+		},
+		{
+			name: "TrailingPunctuation",
+			body: "#go, #rust! #zig? #c.",
+			want: []string{"go", "rust", "zig", "c"},
+		},
+		{
+			name: "InsideBrackets",
+			body: "(#go) [#rust]",
+			want: []string{"go", "rust"},
+		},
+		{
+			name: "Underscores",
+			body: "#hello_world",
+			want: []string{"hello_world"},
+		},
+		{
+			name: "MidWord",
+			body: "C# and foo#bar",
+			want: []string{},
+		},
+		{
+			name: "URLFragments",
+			body: "Read https://example.com/docs#install and https://example.com/#intro",
+			want: []string{},
+		},
+		{
+			name: "HTMLEntity",
+			body: "&#123; is a brace",
+			want: []string{},
+		},
+		{
+			name: "DoubleHash",
+			body: "##go",
+			want: []string{},
+		},
+		{
+			name: "DigitsOnly",
+			body: "We're #1 in #2024",
+			want: []string{},
+		},
+		{
+			name: "LettersAndDigits",
+			body: "#web3 #2fast",
+			want: []string{"web3", "2fast"},
+		},
+		{
+			name: "OtherScripts",
+			body: "#日本語 #Ελλάδα #мир",
+			want: []string{"日本語", "ελλάδα", "мир"},
+		},
+		{
+			name: "CombiningMarks",
+			body: "#cafe\u0301 and #caf\u00e9",
+			want: []string{"café"},
+		},
+		{
+			name: "Duplicates",
+			body: "#Go #go #GO #rust #go",
+			want: []string{"go", "rust"},
+		},
+		{
+			name: "AtMaxLength",
+			body: "#" + strings.Repeat("a", maxTagLength),
+			want: []string{strings.Repeat("a", maxTagLength)},
+		},
+		{
+			name: "TooLong",
+			body: "#" + strings.Repeat("a", maxTagLength+1) + " #ok",
+			want: []string{"ok"},
+		},
+		{
+			name: "MaxLengthCountsCharacters",
+			body: "#" + strings.Repeat("é", maxTagLength),
+			want: []string{strings.Repeat("é", maxTagLength)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := extractHashtags(tt.body)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Expected %q, but got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestNormalizeTag(t *testing.T) {
+	tests := []struct {
+		name string
+		tag  string
+		want string
+	}{
+		{name: "Plain", tag: "go", want: "go"},
+		{name: "LeadingHash", tag: "#Go", want: "go"},
+		{name: "Decomposed", tag: "Cafe\u0301", want: "café"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := normalizeTag(tt.tag)
+			if got != tt.want {
+				t.Errorf("Expected %q, but got %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/dbfletcher/chirpy/internal/database"
+)
+
+const (
+	minHandleLength      = 3
+	maxHandleLength      = 30
+	maxDisplayNameLength = 50
+	maxBioLength         = 160
+	minPasswordLength    = 8
+)
+
+// handleRegexp is the shape of a handle. It uses the same characters
+// mentionRegexp accepts after the @.
+var handleRegexp = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// reservedHandles can't be claimed because they would collide with routes or
+// could be mistaken for the service itself. Compared case-insensitively.
+var reservedHandles = map[string]bool{
+	"about":         true,
+	"admin":         true,
+	"administrator": true,
+	"api":           true,
+	"chirpy":        true,
+	"everyone":      true,
+	"help":          true,
+	"login":         true,
+	"logout":        true,
+	"me":            true,
+	"mod":           true,
+	"moderator":     true,
+	"notifications": true,
+	"null":          true,
+	"root":          true,
+	"security":      true,
+	"settings":      true,
+	"signup":        true,
+	"staff":         true,
+	"support":       true,
+	"system":        true,
+}
+
+// userFromDB maps a database row onto the private user representation
+// returned to the user themselves.
+func userFromDB(dbUser database.User) User {
+	user := User{
+		ID:            dbUser.ID,
+		CreatedAt:     dbUser.CreatedAt,
+		UpdatedAt:     dbUser.UpdatedAt,
+		Email:         dbUser.Email,
+		IsChirpyRed:   dbUser.IsChirpyRed,
+		DisplayName:   dbUser.DisplayName,
+		Bio:           dbUser.Bio,
+		EmailVerified: dbUser.EmailVerifiedAt.Valid,
+		IsAdmin:       dbUser.IsAdmin,
+	}
+	if dbUser.Handle.Valid {
+		handle := dbUser.Handle.String
+		user.Handle = &handle
+	}
+	return user
+}
+
+// validateHandle checks a handle a user wants to claim. Uniqueness is left to
+// the database.
+func validateHandle(handle string) error {
+	if len(handle) < minHandleLength || len(handle) > maxHandleLength {
+		return fmt.Errorf("handle must be between %d and %d characters", minHandleLength, maxHandleLength)
+	}
+	if !handleRegexp.MatchString(handle) {
+		return errors.New("handle can only contain letters, digits and underscores")
+	}
+	if strings.Trim(handle, "0123456789_") == "" {
+		return errors.New("handle must contain a letter")
+	}
+	if reservedHandles[strings.ToLower(handle)] {
+		return errors.New("handle is reserved")
+	}
+	return nil
+}
+
+// validateProfile checks the free-text profile fields, which are measured in
+// characters rather than bytes.
+func validateProfile(displayName, bio string) error {
+	if utf8.RuneCountInString(displayName) > maxDisplayNameLength {
+		return errors.New("display_name is too long")
+	}
+	if utf8.RuneCountInString(bio) > maxBioLength {
+		return errors.New("bio is too long")
+	}
+	return nil
+}